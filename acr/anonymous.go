@@ -0,0 +1,66 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Scope used to probe whether a registry hands out anonymous pull tokens.
+// Anonymous pull is a registry-wide setting, so any repository name works.
+const AnonymousProbeScope = "repository:anonymous-probe:pull"
+
+// AnonymousPullChecker is implemented by authenticators that can detect
+// registries with anonymous pull enabled
+type AnonymousPullChecker interface {
+	CheckAnonymousPull(registryHost string) (bool, error)
+}
+
+// CheckAnonymousPull requests a pull token from the registry without any
+// credentials. It reports true when the registry issues one.
+func (a *AzureAuthenticator) CheckAnonymousPull(registryHost string) (bool, error) {
+	realm, service := a.resolveTokenEndpoint(registryHost)
+
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return false, fmt.Errorf("invalid token endpoint %q: %w", realm, err)
+	}
+	tokenURL.RawQuery = url.Values{
+		"service": []string{service},
+		"scope":   []string{AnonymousProbeScope},
+	}.Encode()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ChallengeProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", tokenURL.String(), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create anonymous token request: %w", err)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("anonymous token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("anonymous token request returned status %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		Token       string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return false, fmt.Errorf("failed to parse anonymous token response: %w", err)
+	}
+
+	return tokenResp.AccessToken != "" || tokenResp.Token != "", nil
+}
@@ -0,0 +1,62 @@
+package acr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newAnonymousTokenServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case RegistryProbePath:
+			w.WriteHeader(http.StatusInternalServerError)
+		case ACRTokenPath:
+			if r.Header.Get("Authorization") != "" {
+				t.Errorf("anonymous probe must not send credentials")
+			}
+			if got := r.URL.Query().Get("scope"); got != AnonymousProbeScope {
+				t.Errorf("unexpected scope: %s", got)
+			}
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckAnonymousPull(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		anonymous bool
+		wantErr   bool
+	}{
+		{name: "token issued", status: http.StatusOK, body: `{"access_token":"anon"}`, anonymous: true},
+		{name: "empty token", status: http.StatusOK, body: `{}`},
+		{name: "unauthorized", status: http.StatusUnauthorized, body: `{"errors":[]}`},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newAnonymousTokenServer(t, tt.status, tt.body)
+			auth := NewAzureAuthenticator()
+			auth.httpClient = server.Client()
+
+			anonymous, err := auth.CheckAnonymousPull(strings.TrimPrefix(server.URL, "https://"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if anonymous != tt.anonymous {
+				t.Errorf("expected anonymous=%v, got %v", tt.anonymous, anonymous)
+			}
+		})
+	}
+}
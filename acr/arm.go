@@ -0,0 +1,204 @@
+package acr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// Azure Resource Manager endpoint of the public cloud
+	DefaultARMEndpoint = "https://management.azure.com"
+
+	// Azure Resource Manager scope of the public cloud; the scope requested
+	// is derived from the configured ARM endpoint
+	ManagementScope = DefaultARMEndpoint + "/.default"
+
+	// API versions of the ARM operations used by the helper
+	registryAPIVersion      = "2023-07-01"
+	resourceGraphAPIVersion = "2021-03-01"
+)
+
+// registryResourceIDRegex matches the ARM resource ID of a container registry
+var registryResourceIDRegex = regexp.MustCompile(
+	`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.ContainerRegistry/registries/[^/]+$`,
+)
+
+// AdminCredentialProvider is implemented by authenticators that can read the
+// admin user credentials of a registry from Azure Resource Manager
+type AdminCredentialProvider interface {
+	// GetAdminCredentials returns the admin username and password. An empty
+	// resourceID is resolved from the registry's login server.
+	GetAdminCredentials(registryHost, resourceID, tenantID string) (string, string, error)
+}
+
+// armCache keeps resolved resource IDs per registry host
+type armCache struct {
+	mu          sync.Mutex
+	resourceIDs map[string]string
+}
+
+func (c *armCache) resourceID(registryHost string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.resourceIDs[registryHost]
+	return id, ok
+}
+
+func (c *armCache) setResourceID(registryHost, id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resourceIDs == nil {
+		c.resourceIDs = map[string]string{}
+	}
+	c.resourceIDs[registryHost] = id
+}
+
+// validateARMEndpoint checks that an ARM endpoint override is an https URL
+func validateARMEndpoint(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("invalid ARM endpoint %q: must be an https URL", endpoint)
+	}
+	return nil
+}
+
+// armEndpointURL returns the configured ARM endpoint without trailing slash
+func (a *AzureAuthenticator) armEndpointURL() string {
+	if a.armEndpoint == "" {
+		return DefaultARMEndpoint
+	}
+	return strings.TrimSuffix(a.armEndpoint, "/")
+}
+
+// managementScope returns the token scope of the ARM endpoint, e.g.
+// https://management.usgovcloudapi.net/.default in Azure Government
+func (a *AzureAuthenticator) managementScope() string {
+	return a.armEndpointURL() + "/.default"
+}
+
+// GetAdminCredentials calls the listCredentials action on the registry
+// resource using a management token. Resolved resource IDs are cached per
+// registry host; the credentials are cached by the helper.
+func (a *AzureAuthenticator) GetAdminCredentials(registryHost, resourceID, tenantID string) (string, string, error) {
+	managementToken, err := a.GetAzureAccessTokenFor(a.managementScope(), tenantID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if resourceID == "" {
+		if resourceID, err = a.lookupRegistryResourceID(managementToken, registryHost); err != nil {
+			return "", "", err
+		}
+	}
+
+	var listResp struct {
+		Username  string `json:"username"`
+		Passwords []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"passwords"`
+	}
+	path := resourceID + "/listCredentials?api-version=" + registryAPIVersion
+	if err := a.armPost(managementToken, path, nil, &listResp); err != nil {
+		return "", "", fmt.Errorf("listCredentials on %s failed: %w", resourceID, err)
+	}
+
+	if listResp.Username == "" || len(listResp.Passwords) == 0 || listResp.Passwords[0].Value == "" {
+		return "", "", fmt.Errorf("listCredentials on %s returned no credentials", resourceID)
+	}
+
+	return listResp.Username, listResp.Passwords[0].Value, nil
+}
+
+// lookupRegistryResourceID finds the registry resource with the given login
+// server using Azure Resource Graph
+func (a *AzureAuthenticator) lookupRegistryResourceID(managementToken, registryHost string) (string, error) {
+	if id, ok := a.arm.resourceID(registryHost); ok {
+		return id, nil
+	}
+
+	query := map[string]string{
+		"query": "resources" +
+			" | where type =~ 'microsoft.containerregistry/registries'" +
+			" | where properties.loginServer =~ '" + registryHost + "'" +
+			" | project id",
+	}
+
+	var graphResp struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	path := "/providers/Microsoft.ResourceGraph/resources?api-version=" + resourceGraphAPIVersion
+	if err := a.armPost(managementToken, path, query, &graphResp); err != nil {
+		return "", fmt.Errorf("resource graph lookup for %s failed: %w", registryHost, err)
+	}
+
+	switch len(graphResp.Data) {
+	case 0:
+		return "", fmt.Errorf("registry %s not found in resource graph; set resourceId for it", registryHost)
+	case 1:
+	default:
+		return "", fmt.Errorf("registry %s matches %d resources; set resourceId for it", registryHost, len(graphResp.Data))
+	}
+
+	id := graphResp.Data[0].ID
+	if !registryResourceIDRegex.MatchString(id) {
+		return "", fmt.Errorf("resource graph returned invalid registry resource ID %q", id)
+	}
+
+	a.arm.setResourceID(registryHost, id)
+	return id, nil
+}
+
+// armPost sends a POST request to the ARM endpoint and decodes the JSON response
+func (a *AzureAuthenticator) armPost(managementToken, path string, body, result any) error {
+	return a.armRequest("POST", managementToken, a.armEndpointURL()+path, body, result)
+}
+
+// armRequest sends a request to an ARM URL and decodes the JSON response
+func (a *AzureAuthenticator) armRequest(method, managementToken, requestURL string, body, result any) error {
+	var payload io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TokenRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, payload)
+	if err != nil {
+		return fmt.Errorf("failed to create ARM request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+managementToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ARM request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return fmt.Errorf("ARM request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse ARM response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const testRegistryResourceID = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerRegistry/registries/legacy"
+
+// staticCredential is an azcore.TokenCredential returning a fixed token
+type staticCredential struct {
+	scopes []string
+}
+
+func (c *staticCredential) GetToken(_ context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.scopes = append(c.scopes, opts.Scopes...)
+	return azcore.AccessToken{Token: "management-token", ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// newFakeARM serves resource graph queries and listCredentials for the
+// legacy registry and counts the requests
+func newFakeARM(t *testing.T, graphCalls, listCalls *atomic.Int32) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Authorization") != "Bearer management-token" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/providers/Microsoft.ResourceGraph/resources":
+			graphCalls.Add(1)
+			var body struct {
+				Query string `json:"query"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if !strings.Contains(body.Query, "'legacy.azurecr.io'") {
+				w.Write([]byte(`{"data":[]}`))
+				return
+			}
+			w.Write([]byte(`{"data":[{"id":"` + testRegistryResourceID + `"}]}`))
+		case testRegistryResourceID + "/listCredentials":
+			listCalls.Add(1)
+			if r.URL.Query().Get("api-version") == "" {
+				t.Error("missing api-version")
+			}
+			w.Write([]byte(`{"username":"legacy","passwords":[{"name":"password","value":"admin-secret"},{"name":"password2","value":"other"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestGetAdminCredentials_ResourceGraphLookupAndCache(t *testing.T) {
+	var graphCalls, listCalls atomic.Int32
+	server := newFakeARM(t, &graphCalls, &listCalls)
+	defer server.Close()
+
+	cred := &staticCredential{}
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	auth.credential = cred
+	auth.armEndpoint = server.URL
+
+	for i := 0; i < 2; i++ {
+		username, password, err := auth.GetAdminCredentials("legacy.azurecr.io", "", "")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if username != "legacy" || password != "admin-secret" {
+			t.Errorf("expected legacy/admin-secret, got %s/%s", username, password)
+		}
+	}
+
+	// The resource ID is cached; the credentials are cached by the helper
+	if graphCalls.Load() != 1 || listCalls.Load() != 2 {
+		t.Errorf("expected a cached resource ID, got %d graph and %d list calls", graphCalls.Load(), listCalls.Load())
+	}
+	if len(cred.scopes) != 2 || cred.scopes[0] != server.URL+"/.default" {
+		t.Errorf("expected management token requests, got scopes %v", cred.scopes)
+	}
+}
+
+// hostRewriter sends every request to target, recording the requested hosts
+type hostRewriter struct {
+	target *url.URL
+	base   http.RoundTripper
+	hosts  []string
+}
+
+func (h *hostRewriter) RoundTrip(req *http.Request) (*http.Response, error) {
+	h.hosts = append(h.hosts, req.URL.Host)
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = h.target.Scheme, h.target.Host
+	return h.base.RoundTrip(req)
+}
+
+func TestGetAdminCredentials_SovereignCloudScope(t *testing.T) {
+	var graphCalls, listCalls atomic.Int32
+	server := newFakeARM(t, &graphCalls, &listCalls)
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	rewriter := &hostRewriter{target: target, base: server.Client().Transport}
+
+	auth, err := NewAzureAuthenticatorFromConfig(&Config{ARMEndpoint: "https://management.usgovcloudapi.net/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := &staticCredential{}
+	auth.httpClient = &http.Client{Transport: rewriter}
+	auth.credential = cred
+
+	if _, _, err := auth.GetAdminCredentials("legacy.azurecr.io", "", ""); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if len(cred.scopes) != 1 || cred.scopes[0] != "https://management.usgovcloudapi.net/.default" {
+		t.Errorf("expected the Azure Government management scope, got %v", cred.scopes)
+	}
+	for _, host := range rewriter.hosts {
+		if host != "management.usgovcloudapi.net" {
+			t.Errorf("expected requests to the configured endpoint, got %s", host)
+		}
+	}
+}
+
+func TestGetAdminCredentials_ConfiguredResourceID(t *testing.T) {
+	var graphCalls, listCalls atomic.Int32
+	server := newFakeARM(t, &graphCalls, &listCalls)
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	auth.credential = &staticCredential{}
+	auth.armEndpoint = server.URL + "/"
+
+	if _, _, err := auth.GetAdminCredentials("legacy.azurecr.io", testRegistryResourceID, ""); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if graphCalls.Load() != 0 {
+		t.Errorf("expected no resource graph lookup, got %d", graphCalls.Load())
+	}
+}
+
+func TestGetAdminCredentials_NotFound(t *testing.T) {
+	var graphCalls, listCalls atomic.Int32
+	server := newFakeARM(t, &graphCalls, &listCalls)
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	auth.credential = &staticCredential{}
+	auth.armEndpoint = server.URL
+
+	_, _, err := auth.GetAdminCredentials("unknown.azurecr.io", "", "")
+	if err == nil || !strings.Contains(err.Error(), "not found in resource graph") {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+}
+
+func TestNewAzureAuthenticatorFromConfig_InvalidARMEndpoint(t *testing.T) {
+	_, err := NewAzureAuthenticatorFromConfig(&Config{ARMEndpoint: "http://localhost:8080"})
+	if err == nil || !strings.Contains(err.Error(), "must be an https URL") {
+		t.Errorf("expected invalid endpoint error, got: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package acr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client assertion sources for workload identity federation
+const (
+	AssertionSourceFile    = "file"
+	AssertionSourceEnv     = "env"
+	AssertionSourceCommand = "command"
+	AssertionSourceGitHub  = "github"
+)
+
+const (
+	// Audience Azure AD expects in federated identity tokens
+	FederatedTokenAudience = "api://AzureADTokenExchange"
+
+	// Assertions are re-read this long before they expire
+	assertionExpiryMargin = time.Minute
+)
+
+// AssertionConfig selects where federated OIDC tokens are read from
+type AssertionConfig struct {
+	// Source is one of file, env, command or github; empty disables
+	// workload identity federation
+	Source string `json:"source,omitempty"`
+
+	// Value is the file path, environment variable name or shell command,
+	// depending on Source (unused for github)
+	Value string `json:"value,omitempty"`
+}
+
+// AssertionSource returns an OIDC token used as client assertion
+type AssertionSource interface {
+	Assertion(ctx context.Context) (string, error)
+}
+
+// NewAssertionSource creates the source described by cfg. The returned source
+// caches the assertion and re-reads it shortly before it expires.
+func NewAssertionSource(cfg AssertionConfig, httpClient *http.Client) (AssertionSource, error) {
+	var source AssertionSource
+
+	switch cfg.Source {
+	case AssertionSourceFile:
+		if cfg.Value == "" {
+			return nil, fmt.Errorf("assertion source %q requires a file path", cfg.Source)
+		}
+		source = fileAssertionSource(cfg.Value)
+	case AssertionSourceEnv:
+		if cfg.Value == "" {
+			return nil, fmt.Errorf("assertion source %q requires an environment variable name", cfg.Source)
+		}
+		source = envAssertionSource(cfg.Value)
+	case AssertionSourceCommand:
+		if cfg.Value == "" {
+			return nil, fmt.Errorf("assertion source %q requires a command", cfg.Source)
+		}
+		source = commandAssertionSource(cfg.Value)
+	case AssertionSourceGitHub:
+		source = &githubAssertionSource{httpClient: httpClient}
+	default:
+		return nil, fmt.Errorf(
+			"unsupported assertion source %q: must be file, env, command or github",
+			cfg.Source,
+		)
+	}
+
+	return &cachedAssertionSource{source: source}, nil
+}
+
+// fileAssertionSource reads the token from a file (e.g. a projected
+// service account token or one written by a CI step)
+type fileAssertionSource string
+
+func (f fileAssertionSource) Assertion(_ context.Context) (string, error) {
+	data, err := os.ReadFile(filepath.Clean(string(f)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read assertion file: %w", err)
+	}
+	return nonEmptySecret(string(data), "assertion file "+string(f))
+}
+
+// envAssertionSource reads the token from an environment variable
+// (e.g. a GitLab CI id_token)
+type envAssertionSource string
+
+func (e envAssertionSource) Assertion(_ context.Context) (string, error) {
+	return nonEmptySecret(os.Getenv(string(e)), "environment variable "+string(e))
+}
+
+// commandAssertionSource runs a shell command that prints the token
+// (e.g. `buildkite-agent oidc request-token --audience ...`)
+type commandAssertionSource string
+
+func (c commandAssertionSource) Assertion(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, TokenRequestTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := CommandFor(ctx, string(c))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("assertion command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nonEmptySecret(stdout.String(), "assertion command output")
+}
+
+// githubAssertionSource requests an OIDC token from the GitHub Actions runtime
+type githubAssertionSource struct {
+	httpClient *http.Client
+}
+
+func (g *githubAssertionSource) Assertion(ctx context.Context) (string, error) {
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", fmt.Errorf(
+			"ACTIONS_ID_TOKEN_REQUEST_URL and ACTIONS_ID_TOKEN_REQUEST_TOKEN are not set; " +
+				"grant the workflow 'id-token: write' permission",
+		)
+	}
+
+	tokenURL, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+	}
+	query := tokenURL.Query()
+	query.Set("audience", FederatedTokenAudience)
+	tokenURL.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, TokenRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", tokenURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create GitHub OIDC request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GitHub OIDC request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GitHub OIDC request failed with status %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("failed to parse GitHub OIDC response: %w", err)
+	}
+
+	return nonEmptySecret(tokenResp.Value, "GitHub OIDC response")
+}
+
+// cachedAssertionSource reuses an assertion until shortly before its 'exp'
+// claim. Assertions without a readable expiry are fetched on every use.
+type cachedAssertionSource struct {
+	source AssertionSource
+
+	mu        sync.Mutex
+	assertion string
+	expiresAt time.Time
+}
+
+func (c *cachedAssertionSource) Assertion(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.assertion != "" && time.Now().Add(assertionExpiryMargin).Before(c.expiresAt) {
+		return c.assertion, nil
+	}
+
+	assertion, err := c.source.Assertion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	c.assertion = assertion
+	c.expiresAt, _ = TokenExpiry(assertion)
+	return assertion, nil
+}
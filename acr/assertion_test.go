@@ -0,0 +1,138 @@
+package acr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssertionSource_File_RereadsExpiredToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	expired := makeToken(t, time.Now().Add(-time.Minute))
+	if err := os.WriteFile(path, []byte(expired+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewAssertionSource(AssertionConfig{Source: AssertionSourceFile, Value: path}, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	got, err := source.Assertion(context.Background())
+	if err != nil || got != expired {
+		t.Fatalf("expected trimmed file content, got %q (%v)", got, err)
+	}
+
+	fresh := makeToken(t, time.Now().Add(time.Hour))
+	if err := os.WriteFile(path, []byte(fresh), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := source.Assertion(context.Background()); got != fresh {
+		t.Errorf("expected expired assertion to be re-read, got %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := source.Assertion(context.Background()); got != fresh {
+		t.Errorf("expected valid assertion to be cached, got %q", got)
+	}
+}
+
+func TestAssertionSource_EnvAndCommand(t *testing.T) {
+	t.Setenv("CI_JOB_JWT_TEST", "env-token")
+
+	source, err := NewAssertionSource(AssertionConfig{Source: AssertionSourceEnv, Value: "CI_JOB_JWT_TEST"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := source.Assertion(context.Background()); err != nil || got != "env-token" {
+		t.Errorf("expected env-token, got %q (%v)", got, err)
+	}
+
+	source, err = NewAssertionSource(AssertionConfig{Source: AssertionSourceCommand, Value: "echo command-token"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := source.Assertion(context.Background()); err != nil || got != "command-token" {
+		t.Errorf("expected command-token, got %q (%v)", got, err)
+	}
+
+	source, err = NewAssertionSource(AssertionConfig{Source: AssertionSourceCommand, Value: "echo oops >&2; exit 3"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Assertion(context.Background()); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected command failure including stderr, got: %v", err)
+	}
+}
+
+func TestAssertionSource_GitHub(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if got := r.URL.Query().Get("audience"); got != FederatedTokenAudience {
+			t.Errorf("unexpected audience: %s", got)
+		}
+		if got := r.URL.Query().Get("api-version"); got != "2.0" {
+			t.Errorf("expected existing query to be kept, got api-version=%s", got)
+		}
+		w.Write([]byte(`{"value":"github-token"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_URL", server.URL+"/token?api-version=2.0")
+	t.Setenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN", "request-token")
+
+	source, err := NewAssertionSource(AssertionConfig{Source: AssertionSourceGitHub}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := source.Assertion(context.Background()); err != nil || got != "github-token" {
+		t.Errorf("expected github-token, got %q (%v)", got, err)
+	}
+}
+
+func TestNewAssertionSource_Invalid(t *testing.T) {
+	for _, cfg := range []AssertionConfig{
+		{Source: "vault"},
+		{Source: AssertionSourceFile},
+		{Source: AssertionSourceEnv},
+		{Source: AssertionSourceCommand},
+	} {
+		if _, err := NewAssertionSource(cfg, nil); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestNewAzureAuthenticatorFromConfig_AssertionRequiresAppRegistration(t *testing.T) {
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_TENANT_ID", "")
+
+	_, err := NewAzureAuthenticatorFromConfig(&Config{
+		Assertion: AssertionConfig{Source: AssertionSourceEnv, Value: "TOKEN"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "AZURE_CLIENT_ID") {
+		t.Errorf("expected missing client ID error, got: %v", err)
+	}
+
+	t.Setenv("AZURE_CLIENT_ID", "00000000-0000-0000-0000-000000000001")
+	t.Setenv("AZURE_TENANT_ID", "00000000-0000-0000-0000-000000000002")
+	auth, err := NewAzureAuthenticatorFromConfig(&Config{
+		Assertion: AssertionConfig{Source: AssertionSourceEnv, Value: "TOKEN"},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if auth.credential == nil {
+		t.Error("expected client assertion credential to be configured")
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/golang-jwt/jwt/v5"
@@ -22,6 +25,9 @@ const (
 	// ACR token exchange endpoint path
 	ACRTokenExchangePath = "/oauth2/exchange" // #nosec G101
 
+	// ACR access token endpoint path
+	ACRTokenPath = "/oauth2/token" // #nosec G101
+
 	// Request timeout for token operations
 	TokenRequestTimeout = 30 * time.Second
 )
@@ -29,6 +35,36 @@ const (
 // AzureAuthenticator handles Azure and ACR authentication
 type AzureAuthenticator struct {
 	httpClient *http.Client
+	challenges challengeCache
+	tenants    tenantCache
+
+	// credential overrides DefaultAzureCredential when set
+	credential azcore.TokenCredential
+
+	// newCredential creates credential on first use, so commands that never
+	// request a token do not read the authentication record or open the
+	// persistent token cache
+	newCredential  func() (azcore.TokenCredential, error)
+	credentialOnce sync.Once
+	credentialErr  error
+
+	// credentialKind is the configured credential, for CredentialIdentity
+	credentialKind string
+
+	// allowedTenants are tenants besides the home tenant tokens may be requested for
+	allowedTenants []string
+
+	// trustedRealmHosts may serve token endpoints of registries besides ACR
+	// hosts, e.g. a registry proxy
+	trustedRealmHosts []string
+
+	// cache keeps discovered challenges between invocations (nil to keep
+	// them in memory)
+	cache CacheStore
+
+	// armEndpoint overrides DefaultARMEndpoint when set
+	armEndpoint string
+	arm         armCache
 }
 
 // NewAzureAuthenticator creates a new authenticator
@@ -40,21 +76,192 @@ func NewAzureAuthenticator() *AzureAuthenticator {
 	}
 }
 
-// GetAzureAccessToken obtains an Azure access token using DefaultAzureCredential
+// NewAzureAuthenticatorWithTransport creates an authenticator whose Azure AD
+// and ACR requests share the configured proxy and TLS settings
+func NewAzureAuthenticatorWithTransport(tc TransportConfig) (*AzureAuthenticator, error) {
+	httpClient, err := NewHTTPClient(tc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transport configuration: %w", err)
+	}
+
+	return &AzureAuthenticator{httpClient: httpClient}, nil
+}
+
+// NewAzureAuthenticatorFromConfig creates an authenticator using the transport
+// and credential settings of cfg
+func NewAzureAuthenticatorFromConfig(cfg *Config) (*AzureAuthenticator, error) {
+	a, err := NewAzureAuthenticatorWithTransport(cfg.Transport)
+	if err != nil {
+		return nil, err
+	}
+	a.allowedTenants = cfg.AllowedTenants()
+	a.trustedRealmHosts = cfg.TrustedRealmHosts
+
+	if cfg.ARMEndpoint != "" {
+		if err := validateARMEndpoint(cfg.ARMEndpoint); err != nil {
+			return nil, err
+		}
+		a.armEndpoint = cfg.ARMEndpoint
+	}
+
+	if cfg.Plugin.Command != "" {
+		if (cfg.Credential != "" && cfg.Credential != CredentialDefault) || cfg.Assertion.Source != "" {
+			return nil, fmt.Errorf("a plugin cannot be combined with a credential or assertion source")
+		}
+		if a.credential, err = NewExecPlugin(cfg.Plugin); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+
+	switch cfg.Credential {
+	case "", CredentialDefault:
+		if cfg.Assertion.Source != "" {
+			// Without the persistent cache tokens are kept in memory
+			var tokenCache azidentity.Cache
+			if cfg.PersistentCache {
+				if tokenCache, err = newPersistentCache(); err != nil {
+					return nil, err
+				}
+			}
+			if a.credential, err = a.newClientAssertionCredential(cfg.Assertion, tokenCache); err != nil {
+				return nil, err
+			}
+			a.credentialKind = credentialAssertion
+			break
+		}
+		a.newCredential = a.loginCredential
+	case CredentialDeviceCode, CredentialBrowser:
+		if cfg.Assertion.Source != "" {
+			return nil, fmt.Errorf("credential %q cannot be combined with an assertion source", cfg.Credential)
+		}
+		kind := cfg.Credential
+		a.credentialKind = kind
+		a.newCredential = func() (azcore.TokenCredential, error) {
+			recordPath, err := DefaultAuthenticationRecordFile()
+			if err != nil {
+				return nil, err
+			}
+			// With tokens only in memory every call would prompt again
+			tokenCache, err := newPersistentCache()
+			if err != nil {
+				return nil, fmt.Errorf("%w; %s sign-in requires it", err, kind)
+			}
+			return newInteractiveCredential(kind, recordPath, InteractiveOptions{
+				Cache:             tokenCache,
+				Transport:         a.httpClient,
+				AdditionalTenants: a.allowedTenants,
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported credential %q: must be default, devicecode or browser", cfg.Credential)
+	}
+
+	return a, nil
+}
+
+// loginCredential returns a credential for the account signed in with the
+// login command, or nil when there is none. It never prompts; an expired
+// sign-in asks the user to log in again.
+func (a *AzureAuthenticator) loginCredential() (azcore.TokenCredential, error) {
+	recordPath, err := DefaultAuthenticationRecordFile()
+	if err != nil {
+		return nil, err
+	}
+	record, err := LoadAuthenticationRecord(recordPath)
+	if err != nil {
+		return nil, err
+	}
+	if record == (azidentity.AuthenticationRecord{}) {
+		return nil, nil
+	}
+
+	tokenCache, err := newPersistentCache()
+	if err != nil {
+		return nil, err
+	}
+
+	// The flow does not matter as the credential never signs in itself
+	return newInteractiveCredential(CredentialDeviceCode, recordPath, InteractiveOptions{
+		Cache:             tokenCache,
+		LoginRequired:     true,
+		Transport:         a.httpClient,
+		AdditionalTenants: a.allowedTenants,
+	})
+}
+
+// newClientAssertionCredential builds a workload identity federation
+// credential from an OIDC token source. The app registration is taken from
+// AZURE_CLIENT_ID and AZURE_TENANT_ID.
+func (a *AzureAuthenticator) newClientAssertionCredential(cfg AssertionConfig, tokenCache azidentity.Cache) (azcore.TokenCredential, error) {
+	source, err := NewAssertionSource(cfg, a.httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	if clientID == "" || tenantID == "" {
+		return nil, fmt.Errorf("workload identity federation requires AZURE_CLIENT_ID and AZURE_TENANT_ID")
+	}
+
+	cred, err := azidentity.NewClientAssertionCredential(tenantID, clientID, source.Assertion,
+		&azidentity.ClientAssertionCredentialOptions{
+			ClientOptions:              azcore.ClientOptions{Transport: a.httpClient},
+			AdditionallyAllowedTenants: a.allowedTenants,
+			Cache:                      tokenCache,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client assertion credential: %w", err)
+	}
+
+	return cred, nil
+}
+
+// tokenCredential returns the configured credential, defaulting to
+// DefaultAzureCredential
+func (a *AzureAuthenticator) tokenCredential() (azcore.TokenCredential, error) {
+	a.credentialOnce.Do(func() {
+		if a.credential == nil && a.newCredential != nil {
+			a.credential, a.credentialErr = a.newCredential()
+		}
+	})
+	if a.credentialErr != nil {
+		return nil, a.credentialErr
+	}
+	if a.credential != nil {
+		return a.credential, nil
+	}
+
+	// This will try: environment variables, managed identity, Azure CLI, etc.
+	return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions:              azcore.ClientOptions{Transport: a.httpClient},
+		AdditionallyAllowedTenants: a.allowedTenants,
+	})
+}
+
+// GetAzureAccessToken obtains an Azure access token for ACR using the
+// configured credential (DefaultAzureCredential unless overridden)
 func (a *AzureAuthenticator) GetAzureAccessToken() (string, error) {
+	return a.GetAzureAccessTokenFor(ACRScope, "")
+}
+
+// GetAzureAccessTokenFor obtains an Azure access token for scope. A non-empty
+// tenantID requests the token from that tenant instead of the home tenant;
+// it must be the home tenant or one of the additionally allowed tenants.
+func (a *AzureAuthenticator) GetAzureAccessTokenFor(scope, tenantID string) (string, error) {
 	// Create credential using Azure Identity SDK
-	// This will try: environment variables, managed identity, Azure CLI, etc.
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	cred, err := a.tokenCredential()
 	if err != nil {
 		return "", fmt.Errorf("failed to create Azure credential: %w", err)
 	}
 
-	// Get access token for ACR scope
 	ctx, cancel := context.WithTimeout(context.Background(), TokenRequestTimeout)
 	defer cancel()
 
 	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{
-		Scopes: []string{ACRScope},
+		Scopes:   []string{scope},
+		TenantID: tenantID,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to get Azure access token: %w", err)
@@ -89,6 +296,26 @@ func (a *AzureAuthenticator) ExtractTenantIDFromToken(azureToken string) (string
 	return tenantID, nil
 }
 
+// TokenExpiry returns the expiry time from the 'exp' claim of a JWT such as
+// an ACR refresh token. The signature is not verified.
+func TokenExpiry(token string) (time.Time, error) {
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(token, claims)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse JWT: %w", err)
+	}
+
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid exp claim: %w", err)
+	}
+	if exp == nil {
+		return time.Time{}, fmt.Errorf("exp claim not found in token")
+	}
+
+	return exp.Time, nil
+}
+
 // ACRTokenResponse represents the JSON response from ACR token exchange
 type ACRTokenResponse struct {
 	RefreshToken string `json:"refresh_token"`
@@ -100,13 +327,13 @@ func (a *AzureAuthenticator) ExchangeForACRToken(
 	tenantID string,
 	azureToken string,
 ) (string, error) {
-	// Construct the token exchange URL
-	exchangeURL := fmt.Sprintf("https://%s%s", registryHost, ACRTokenExchangePath)
+	// Resolve the token exchange URL and service (discovered via /v2/ challenge)
+	exchangeURL, service := a.resolveExchangeEndpoint(registryHost)
 
 	// Prepare form data
 	formData := url.Values{
 		"grant_type":   []string{"access_token"},
-		"service":      []string{registryHost},
+		"service":      []string{service},
 		"tenant":       []string{tenantID},
 		"access_token": []string{azureToken},
 	}
@@ -137,11 +364,7 @@ func (a *AzureAuthenticator) ExchangeForACRToken(
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf(
-			"ACR token exchange failed with status %d: %s",
-			resp.StatusCode,
-			string(body),
-		)
+		return "", &ExchangeStatusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	// Parse response
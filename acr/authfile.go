@@ -0,0 +1,127 @@
+package acr
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AuthFile is a containers auth.json or Docker config.json holding static
+// credentials under the "auths" key. Other keys and entries are preserved.
+type AuthFile struct {
+	file  *jsonObjectFile
+	auths map[string]map[string]any
+}
+
+// DefaultContainersAuthFile returns the auth file used by podman, buildah and
+// skopeo: $REGISTRY_AUTH_FILE, then $XDG_RUNTIME_DIR/containers/auth.json,
+// then ~/.config/containers/auth.json
+func DefaultContainersAuthFile() (string, error) {
+	if path := os.Getenv("REGISTRY_AUTH_FILE"); path != "" {
+		return path, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "containers", "auth.json"), nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine auth file location: %w", err)
+	}
+	return filepath.Join(configDir, "containers", "auth.json"), nil
+}
+
+// DefaultDockerConfigFile returns $DOCKER_CONFIG/config.json, falling back to
+// ~/.docker/config.json
+func DefaultDockerConfigFile() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine Docker config location: %w", err)
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// LoadAuthFile reads an auth file; a missing file yields an empty one
+func LoadAuthFile(path string) (*AuthFile, error) {
+	file, err := loadJSONObjectFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	auths := map[string]map[string]any{}
+	if _, err := file.get("auths", &auths); err != nil {
+		return nil, err
+	}
+	if auths == nil {
+		auths = map[string]map[string]any{}
+	}
+
+	return &AuthFile{file: file, auths: auths}, nil
+}
+
+// Path returns the location of the auth file
+func (f *AuthFile) Path() string {
+	return f.file.path
+}
+
+// SetCredentials stores username and secret for host as a static entry
+func (f *AuthFile) SetCredentials(host, username, secret string) {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + secret))
+	f.auths[host] = map[string]any{"auth": auth}
+}
+
+// Remove deletes the entry for host; it reports whether an entry existed
+func (f *AuthFile) Remove(host string) bool {
+	_, ok := f.auths[host]
+	delete(f.auths, host)
+	return ok
+}
+
+// PruneExpired removes entries for ACR registries whose stored refresh token
+// has expired at now. It returns the removed hosts in sorted order.
+func (f *AuthFile) PruneExpired(validator *RegistryValidator, now time.Time) []string {
+	var removed []string
+
+	for host, entry := range f.auths {
+		if !validator.IsACRRegistry(host) {
+			continue
+		}
+
+		encoded, _ := entry["auth"].(string)
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		_, secret, found := strings.Cut(string(decoded), ":")
+		if !found {
+			continue
+		}
+
+		expiry, err := TokenExpiry(secret)
+		if err != nil || expiry.After(now) {
+			continue
+		}
+
+		delete(f.auths, host)
+		removed = append(removed, host)
+	}
+
+	sort.Strings(removed)
+	return removed
+}
+
+// Save atomically writes the auth file with owner-only permissions
+func (f *AuthFile) Save() error {
+	if err := f.file.set("auths", f.auths); err != nil {
+		return err
+	}
+	return f.file.save()
+}
@@ -0,0 +1,122 @@
+package acr
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// makeToken builds an unsigned JWT expiring at exp
+func makeToken(t *testing.T, exp time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"exp": exp.Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	return token
+}
+
+func authEntry(secret string) string {
+	return base64.StdEncoding.EncodeToString([]byte("00000000-0000-0000-0000-000000000000:" + secret))
+}
+
+func TestAuthFile_PreservesOtherKeysAndOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	original := "{\n  \"credHelpers\": {\n    \"gcr.io\": \"gcloud\"\n  },\n" +
+		"  \"auths\": {\n    \"quay.io\": {\n      \"auth\": \"dXNlcjpwYXNz\"\n    }\n  }\n}\n"
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	authFile, err := LoadAuthFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	authFile.SetCredentials("myregistry.azurecr.io", "user", "secret")
+	if err := authFile.Save(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+
+	if !strings.HasPrefix(content, "{\n  \"credHelpers\": {\n    \"gcr.io\": \"gcloud\"\n  },\n  \"auths\"") {
+		t.Errorf("expected key order and indentation to be preserved, got:\n%s", content)
+	}
+	if !strings.Contains(content, `"quay.io"`) || !strings.Contains(content, `"myregistry.azurecr.io"`) {
+		t.Errorf("expected both entries, got:\n%s", content)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestAuthFile_MissingFileIsCreated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "containers", "auth.json")
+
+	authFile, err := LoadAuthFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	authFile.SetCredentials("myregistry.azurecr.io", "user", "secret")
+	if err := authFile.Save(); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	reloaded, err := LoadAuthFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !reloaded.Remove("myregistry.azurecr.io") {
+		t.Error("expected entry to be persisted")
+	}
+}
+
+func TestAuthFile_PruneExpired(t *testing.T) {
+	authFile, err := LoadAuthFile(filepath.Join(t.TempDir(), "auth.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	authFile.auths["expired.azurecr.io"] = map[string]any{"auth": authEntry(makeToken(t, now.Add(-time.Hour)))}
+	authFile.auths["current.azurecr.io"] = map[string]any{"auth": authEntry(makeToken(t, now.Add(time.Hour)))}
+	authFile.auths["quay.io"] = map[string]any{"auth": authEntry(makeToken(t, now.Add(-time.Hour)))}
+
+	removed := authFile.PruneExpired(NewRegistryValidator(), now)
+	if len(removed) != 1 || removed[0] != "expired.azurecr.io" {
+		t.Errorf("expected only the expired ACR entry to be removed, got: %v", removed)
+	}
+	if _, ok := authFile.auths["current.azurecr.io"]; !ok {
+		t.Error("expected current entry to be kept")
+	}
+	if _, ok := authFile.auths["quay.io"]; !ok {
+		t.Error("expected non-ACR entry to be kept")
+	}
+}
+
+func TestLoadAuthFile_InvalidJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte("[1, 2]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadAuthFile(path); err == nil {
+		t.Fatal("expected error for non-object JSON, got nil")
+	}
+}
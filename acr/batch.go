@@ -0,0 +1,152 @@
+package acr
+
+import (
+	"sync"
+)
+
+// Default number of concurrent token exchanges for batch retrieval
+const DefaultBatchWorkers = 4
+
+// BatchEntry is the outcome for one registry of a batch request
+type BatchEntry struct {
+	// Input is the server URL as given by the caller
+	Input string `json:"input"`
+
+	// Registry is the normalized registry host (empty if the input was invalid)
+	Registry string `json:"registry,omitempty"`
+
+	Username string `json:"username,omitempty"`
+	Secret   string `json:"secret,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BatchResult holds the per-registry results of a batch request
+type BatchResult struct {
+	Registries []BatchEntry `json:"registries"`
+}
+
+// Failed returns the number of entries that carry an error
+func (r *BatchResult) Failed() int {
+	failed := 0
+	for _, entry := range r.Registries {
+		if entry.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
+
+// GetBatch retrieves credentials for several registries at once. Inputs are
+// normalized, the Azure access token is acquired only once per tenant, and
+// exchanges run concurrently on at most workers goroutines. There is one
+// entry per input, in input order; inputs naming the same registry share its
+// credentials. Failures are reported per entry.
+func (h *ACRHelper) GetBatch(serverURLs []string, workers int) *BatchResult {
+	if workers < 1 {
+		workers = DefaultBatchWorkers
+	}
+
+	result := &BatchResult{}
+	first := map[string]int{}
+	var pending []int
+	duplicates := map[int]int{}
+
+	for _, serverURL := range serverURLs {
+		registryHost, _, err := h.validator.ParseAndNormalize(serverURL)
+		if err != nil {
+			result.Registries = append(result.Registries, BatchEntry{Input: serverURL, Error: err.Error()})
+			continue
+		}
+
+		result.Registries = append(result.Registries, BatchEntry{Input: serverURL, Registry: registryHost})
+		idx := len(result.Registries) - 1
+		if original, ok := first[registryHost]; ok {
+			duplicates[idx] = original
+			continue
+		}
+		first[registryHost] = idx
+		pending = append(pending, idx)
+	}
+
+	// Azure tokens are acquired lazily and at most once per tenant, so a
+	// batch made up of anonymous registries never touches Azure
+	var tokens tenantTokens
+	acquire := func(registryTenant string) (string, string, error) {
+		return tokens.get(registryTenant, h.acquireAzureToken)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(workers, len(pending)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				entry := &result.Registries[idx]
+
+				if username, secret, ok, err := h.configuredCredentials(entry.Registry); ok {
+					entry.Username, entry.Secret = username, secret
+					if err != nil {
+						entry.Error = err.Error()
+					}
+					continue
+				}
+
+				if h.config.Registry(entry.Registry).AnonymousPull && h.allowsAnonymousPull(entry.Registry) {
+					continue
+				}
+
+				var err error
+				entry.Username, entry.Secret, err = h.refreshToken(entry.Registry, cacheExpiryMargin, acquire)
+				if err != nil {
+					entry.Error = err.Error()
+				}
+			}
+		}()
+	}
+
+	for _, idx := range pending {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	for idx, original := range duplicates {
+		entry, fetched := &result.Registries[idx], result.Registries[original]
+		entry.Username, entry.Secret, entry.Error = fetched.Username, fetched.Secret, fetched.Error
+	}
+
+	return result
+}
+
+// tenantTokens acquires one Azure token per registry tenant ("" being the
+// identity's home tenant) and shares it between concurrent callers
+type tenantTokens struct {
+	mu      sync.Mutex
+	entries map[string]*tenantToken
+}
+
+type tenantToken struct {
+	once     sync.Once
+	token    string
+	tenantID string
+	err      error
+}
+
+func (t *tenantTokens) get(registryTenant string, acquire func(string) (string, string, error)) (string, string, error) {
+	t.mu.Lock()
+	if t.entries == nil {
+		t.entries = map[string]*tenantToken{}
+	}
+	entry, ok := t.entries[registryTenant]
+	if !ok {
+		entry = &tenantToken{}
+		t.entries[registryTenant] = entry
+	}
+	t.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.token, entry.tenantID, entry.err = acquire(registryTenant)
+	})
+	return entry.token, entry.tenantID, entry.err
+}
@@ -0,0 +1,103 @@
+package acr
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingAuthenticator records calls and echoes the registry in the token
+type countingAuthenticator struct {
+	fakeAuthenticator
+	tokenCalls    atomic.Int32
+	exchangeCalls atomic.Int32
+
+	mu        sync.Mutex
+	exchanged []string
+}
+
+func (c *countingAuthenticator) GetAzureAccessToken() (string, error) {
+	c.tokenCalls.Add(1)
+	return c.fakeAuthenticator.GetAzureAccessToken()
+}
+
+func (c *countingAuthenticator) ExchangeForACRToken(registryHost, _, _ string) (string, error) {
+	c.exchangeCalls.Add(1)
+	c.mu.Lock()
+	c.exchanged = append(c.exchanged, registryHost)
+	c.mu.Unlock()
+	if c.refreshTokenErr != nil && registryHost == "failing.azurecr.io" {
+		return "", c.refreshTokenErr
+	}
+	return "token-for-" + registryHost, nil
+}
+
+func TestGetBatch_SharesDuplicatesAndAcquiresOnce(t *testing.T) {
+	auth := &countingAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	auth.refreshTokenErr = fmt.Errorf("exchange endpoint returned 403")
+	helper := NewACRHelperWithAuthenticator(auth)
+
+	result := helper.GetBatch([]string{
+		"myregistry.azurecr.io",
+		"https://MyRegistry.azurecr.io/",
+		"registry-1.docker.io",
+		"otherregistry.azurecr.io",
+		"failing.azurecr.io",
+		"failing.azurecr.io",
+	}, 2)
+
+	if auth.tokenCalls.Load() != 1 {
+		t.Errorf("expected one Azure token acquisition, got %d", auth.tokenCalls.Load())
+	}
+	if auth.exchangeCalls.Load() != 3 {
+		t.Errorf("expected three exchanges, got %d (%v)", auth.exchangeCalls.Load(), auth.exchanged)
+	}
+
+	if len(result.Registries) != 6 {
+		t.Fatalf("expected one entry per input, got %d: %+v", len(result.Registries), result.Registries)
+	}
+
+	want := []struct {
+		registry string
+		secret   string
+		failed   bool
+	}{
+		{registry: "myregistry.azurecr.io", secret: "token-for-myregistry.azurecr.io"},
+		{registry: "myregistry.azurecr.io", secret: "token-for-myregistry.azurecr.io"},
+		{failed: true},
+		{registry: "otherregistry.azurecr.io", secret: "token-for-otherregistry.azurecr.io"},
+		{registry: "failing.azurecr.io", failed: true},
+		{registry: "failing.azurecr.io", failed: true},
+	}
+	for i, w := range want {
+		entry := result.Registries[i]
+		if entry.Registry != w.registry || entry.Secret != w.secret || (entry.Error != "") != w.failed {
+			t.Errorf("entry %d: unexpected %+v", i, entry)
+		}
+	}
+
+	if result.Failed() != 3 {
+		t.Errorf("expected 3 failures, got %d", result.Failed())
+	}
+	if got := result.Registries[1].Input; got != "https://MyRegistry.azurecr.io/" {
+		t.Errorf("expected duplicates to keep their input, got %q", got)
+	}
+}
+
+func TestGetBatch_AzureAuthFailureReportedPerRegistry(t *testing.T) {
+	helper := NewACRHelperWithAuthenticator(&fakeAuthenticator{
+		accessTokenErr: fmt.Errorf("no credential providers found"),
+	})
+
+	result := helper.GetBatch([]string{"myregistry.azurecr.io", "otherregistry.azurecr.io"}, 0)
+
+	if result.Failed() != 2 {
+		t.Fatalf("expected both registries to fail, got: %+v", result.Registries)
+	}
+	for _, entry := range result.Registries {
+		if entry.Registry == "" {
+			t.Errorf("expected normalized registry for %s", entry.Input)
+		}
+	}
+}
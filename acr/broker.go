@@ -0,0 +1,321 @@
+package acr
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Path of the broker's credentials endpoint
+const BrokerCredentialsPath = "/v1/credentials"
+
+const (
+	// Prefix of unix socket addresses and URLs
+	unixSocketPrefix = "unix:"
+
+	brokerReadHeaderTimeout = 10 * time.Second
+	brokerShutdownTimeout   = 5 * time.Second
+)
+
+// BrokerConfig makes the helper fetch credentials from a broker started with
+// serve-http instead of authenticating to Azure itself
+type BrokerConfig struct {
+	// URL of the broker: http://127.0.0.1:<port> or unix:///path/to/socket
+	URL string `json:"url,omitempty"`
+
+	// SecretFile contains the shared secret; DOCKER_CREDENTIAL_ACR_BROKER_SECRET
+	// takes precedence
+	SecretFile string `json:"secretFile,omitempty"`
+}
+
+// BrokerCredentials is the broker's response to a credentials request
+type BrokerCredentials struct {
+	Username string `json:"username"`
+	Secret   string `json:"secret"`
+}
+
+// brokerError is the body of error responses
+type brokerError struct {
+	Error string `json:"error"`
+}
+
+// BrokerOptions controls who may request credentials from a broker and for
+// which registries
+type BrokerOptions struct {
+	// Registries credentials are served for; any accepted registry form
+	Registries []string
+
+	// Secret authenticates clients sending "Authorization: Bearer <secret>"
+	Secret string
+
+	// UIDs authenticates clients connecting over a unix socket as one of
+	// these users (Linux only)
+	UIDs []int
+
+	// ErrorLog receives the details of failed credential requests, which
+	// clients are not shown; nil discards them
+	ErrorLog io.Writer
+}
+
+// brokerHandler serves credentials over HTTP
+type brokerHandler struct {
+	helper     *ACRHelper
+	registries map[string]bool
+	secret     string
+	uids       []int
+	errorLog   io.Writer
+}
+
+// NewBrokerHandler returns the HTTP handler of the credential broker
+func NewBrokerHandler(h *ACRHelper, opts BrokerOptions) (http.Handler, error) {
+	if h.broker != nil {
+		return nil, fmt.Errorf("the broker cannot use a broker itself; unset %s", EnvBrokerURL)
+	}
+	if opts.Secret == "" && len(opts.UIDs) == 0 {
+		return nil, fmt.Errorf("a shared secret or allowed users are required")
+	}
+	if len(opts.Registries) == 0 {
+		return nil, fmt.Errorf("no registries allowed")
+	}
+
+	registries := map[string]bool{}
+	for _, registry := range opts.Registries {
+		host, _, err := h.validator.ParseAndNormalize(registry)
+		if err != nil {
+			return nil, err
+		}
+		registries[host] = true
+	}
+
+	errorLog := opts.ErrorLog
+	if errorLog == nil {
+		errorLog = io.Discard
+	}
+
+	return &brokerHandler{helper: h, registries: registries, secret: opts.Secret, uids: opts.UIDs, errorLog: errorLog}, nil
+}
+
+func (b *brokerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != BrokerCredentialsPath {
+		writeBrokerError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeBrokerError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !b.authenticated(r) {
+		writeBrokerError(w, http.StatusUnauthorized, "unauthenticated")
+		return
+	}
+
+	registryHost, _, err := b.helper.validator.ParseAndNormalize(r.URL.Query().Get("registry"))
+	if err != nil {
+		writeBrokerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !b.registries[registryHost] {
+		writeBrokerError(w, http.StatusForbidden, "registry "+registryHost+" is not served by this broker")
+		return
+	}
+
+	// Backend errors may reveal tenants, identities or token endpoints, so
+	// clients only get a generic message
+	username, secret, err := b.helper.Get(registryHost)
+	if err != nil {
+		fmt.Fprintf(b.errorLog, "broker: %s: %v\n", registryHost, err)
+		writeBrokerError(w, http.StatusBadGateway, "failed to obtain credentials for "+registryHost+"; see the broker's log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(BrokerCredentials{Username: username, Secret: secret})
+}
+
+// authenticated accepts the shared secret or an allowed peer user
+func (b *brokerHandler) authenticated(r *http.Request) bool {
+	if b.secret != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(b.secret)) == 1 {
+			return true
+		}
+	}
+
+	uid, ok := r.Context().Value(peerUIDKey{}).(int)
+	return ok && slices.Contains(b.uids, uid)
+}
+
+func writeBrokerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(brokerError{Error: message})
+}
+
+// peerUIDKey is the context key of the peer user of unix socket connections
+type peerUIDKey struct{}
+
+// ListenBroker listens on a loopback TCP address or on "unix:<path>". Stale
+// socket files of brokers that are no longer running are replaced.
+func ListenBroker(address string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(address, unixSocketPrefix)
+	if !isUnix {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", address, err)
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("invalid listen address %q: only loopback addresses and unix sockets are allowed", address)
+		}
+		return net.Listen("tcp", address)
+	}
+
+	path = filepath.Clean(path)
+	listener, err := net.Listen("unix", path)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a broker is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		listener, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Peer credentials decide who may connect
+	if err := os.Chmod(path, 0o666); err != nil { // #nosec G302 -- access is checked per request
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// ServeBroker serves the handler until ctx is done
+func ServeBroker(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: brokerReadHeaderTimeout,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			if uid, ok := peerUID(conn); ok {
+				return context.WithValue(ctx, peerUIDKey{}, uid)
+			}
+			return ctx
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(listener) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), brokerShutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// brokerClient fetches credentials from a broker
+type brokerClient struct {
+	url        string
+	baseURL    string
+	secret     string
+	httpClient *http.Client
+}
+
+// newBrokerClient creates a client for a loopback HTTP or unix socket URL
+func newBrokerClient(cfg BrokerConfig) (*brokerClient, error) {
+	secret := os.Getenv(EnvBrokerSecret)
+	if secret == "" && cfg.SecretFile != "" {
+		data, err := os.ReadFile(filepath.Clean(cfg.SecretFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read broker secret: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+
+	// Never proxied: the broker is local
+	transport := &http.Transport{}
+	c := &brokerClient{url: cfg.URL, secret: secret, httpClient: &http.Client{Transport: transport, Timeout: TokenRequestTimeout + 5*time.Second}}
+
+	brokerURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+	switch brokerURL.Scheme {
+	case "unix":
+		path := brokerURL.Path
+		if path == "" {
+			return nil, fmt.Errorf("invalid broker URL %q: expected unix:///path/to/socket", cfg.URL)
+		}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		c.baseURL = "http://broker"
+	case "http":
+		if ip := net.ParseIP(brokerURL.Hostname()); ip == nil || !ip.IsLoopback() {
+			return nil, fmt.Errorf("invalid broker URL %q: only loopback addresses and unix sockets are allowed", cfg.URL)
+		}
+		c.baseURL = "http://" + brokerURL.Host
+	default:
+		return nil, fmt.Errorf("invalid broker URL %q: expected http://127.0.0.1:<port> or unix:///path/to/socket", cfg.URL)
+	}
+
+	return c, nil
+}
+
+// Credentials requests credentials for the registry from the broker
+func (c *brokerClient) Credentials(registryHost string) (string, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+BrokerCredentialsPath+"?"+url.Values{"registry": []string{registryHost}}.Encode(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	if c.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+c.secret)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("credential broker request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read credential broker response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var failure brokerError
+		if json.Unmarshal(body, &failure) != nil || failure.Error == "" {
+			failure.Error = strings.TrimSpace(string(body))
+		}
+		return "", "", fmt.Errorf("credential broker returned status %d: %s", resp.StatusCode, failure.Error)
+	}
+
+	var creds BrokerCredentials
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return "", "", fmt.Errorf("invalid credential broker response: %w", err)
+	}
+	return creds.Username, creds.Secret, nil
+}
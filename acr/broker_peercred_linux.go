@@ -0,0 +1,35 @@
+//go:build linux
+
+package acr
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// PeerCredentialsSupported reports whether unix socket clients can be
+// authenticated by their user
+const PeerCredentialsSupported = true
+
+// peerUID returns the user of the process at the other end of a unix socket
+// connection (SO_PEERCRED)
+func peerUID(conn net.Conn) (int, bool) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED) // #nosec G115 -- file descriptor
+	}); err != nil || credErr != nil {
+		return 0, false
+	}
+	return int(cred.Uid), true
+}
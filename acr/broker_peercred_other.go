@@ -0,0 +1,14 @@
+//go:build !linux
+
+package acr
+
+import "net"
+
+// PeerCredentialsSupported reports whether unix socket clients can be
+// authenticated by their user
+const PeerCredentialsSupported = false
+
+// peerUID is not implemented on this platform; clients need the shared secret
+func peerUID(net.Conn) (int, bool) {
+	return 0, false
+}
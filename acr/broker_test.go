@@ -0,0 +1,207 @@
+package acr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func newTestBroker(t *testing.T, opts BrokerOptions) http.Handler {
+	t.Helper()
+	handler, err := NewBrokerHandler(NewACRHelperWithAuthenticator(successAuthenticator()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestBrokerHandler(t *testing.T) {
+	handler := newTestBroker(t, BrokerOptions{Registries: []string{"https://myregistry.azurecr.io"}, Secret: "s3cret"})
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		auth       string
+		wantStatus int
+	}{
+		{"credentials", http.MethodGet, "/v1/credentials?registry=myregistry.azurecr.io", "Bearer s3cret", http.StatusOK},
+		{"any registry form", http.MethodGet, "/v1/credentials?registry=https://MyRegistry.azurecr.io", "Bearer s3cret", http.StatusOK},
+		{"wrong secret", http.MethodGet, "/v1/credentials?registry=myregistry.azurecr.io", "Bearer guess", http.StatusUnauthorized},
+		{"no secret", http.MethodGet, "/v1/credentials?registry=myregistry.azurecr.io", "", http.StatusUnauthorized},
+		{"registry not allowed", http.MethodGet, "/v1/credentials?registry=other.azurecr.io", "Bearer s3cret", http.StatusForbidden},
+		{"invalid registry", http.MethodGet, "/v1/credentials?registry=docker.io", "Bearer s3cret", http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/v1/credentials?registry=myregistry.azurecr.io", "Bearer s3cret", http.StatusMethodNotAllowed},
+		{"unknown path", http.MethodGet, "/v1/tokens", "Bearer s3cret", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var creds BrokerCredentials
+			if err := json.Unmarshal(rec.Body.Bytes(), &creds); err != nil {
+				t.Fatal(err)
+			}
+			if creds.Username != ACRRefreshTokenUsername || creds.Secret != "fake-refresh-token-12345" {
+				t.Errorf("unexpected credentials %+v", creds)
+			}
+		})
+	}
+}
+
+func TestBrokerHandler_HidesBackendErrors(t *testing.T) {
+	helper := NewACRHelperWithAuthenticator(&fakeAuthenticator{accessTokenErr: errors.New("AADSTS700016: application 1234 not found in tenant contoso")})
+	var errorLog bytes.Buffer
+	handler, err := NewBrokerHandler(helper, BrokerOptions{Registries: []string{"myregistry.azurecr.io"}, Secret: "s3cret", ErrorLog: &errorLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/credentials?registry=myregistry.azurecr.io", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d: %s", rec.Code, rec.Body)
+	}
+	if strings.Contains(rec.Body.String(), "AADSTS700016") {
+		t.Errorf("expected a generic error for clients, got: %s", rec.Body)
+	}
+	if !strings.Contains(errorLog.String(), "myregistry.azurecr.io") || !strings.Contains(errorLog.String(), "AADSTS700016") {
+		t.Errorf("expected the details in the broker's log, got: %q", errorLog.String())
+	}
+}
+
+func TestNewBrokerHandler_InvalidOptions(t *testing.T) {
+	helper := NewACRHelperWithAuthenticator(successAuthenticator())
+	for _, opts := range []BrokerOptions{
+		{Registries: []string{"myregistry.azurecr.io"}},
+		{Secret: "s3cret"},
+		{Registries: []string{"docker.io"}, Secret: "s3cret"},
+	} {
+		if _, err := NewBrokerHandler(helper, opts); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestListenBroker_RejectsNonLoopback(t *testing.T) {
+	for _, address := range []string{"0.0.0.0:8484", "192.0.2.1:8484", "localhost", ":8484"} {
+		if _, err := ListenBroker(address); err == nil {
+			t.Errorf("expected %q to be rejected", address)
+		}
+	}
+}
+
+func TestGet_Broker(t *testing.T) {
+	handler := newTestBroker(t, BrokerOptions{Registries: []string{"myregistry.azurecr.io"}, Secret: "s3cret"})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := newBrokerClient(BrokerConfig{URL: server.URL, SecretFile: secretFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Azure authentication must not be attempted in client mode
+	helper := NewACRHelperWithAuthenticator(&fakeAuthenticator{accessTokenErr: errors.New("no Azure credential")})
+	helper.broker = client
+
+	username, secret, err := helper.Get("myregistry.azurecr.io")
+	if err != nil || username != ACRRefreshTokenUsername || secret != "fake-refresh-token-12345" {
+		t.Errorf("expected credentials from the broker, got %s/%s, %v", username, secret, err)
+	}
+
+	_, _, err = helper.Get("other.azurecr.io")
+	if err == nil || !strings.Contains(err.Error(), "status 403") || !strings.Contains(err.Error(), "not served by this broker") {
+		t.Errorf("expected broker error, got: %v", err)
+	}
+
+	t.Setenv(EnvBrokerSecret, "wrong")
+	client, _ = newBrokerClient(BrokerConfig{URL: server.URL, SecretFile: secretFile})
+	helper.broker = client
+	if _, _, err := helper.Get("myregistry.azurecr.io"); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected the environment secret to take precedence, got: %v", err)
+	}
+}
+
+func TestNewBrokerClient_InvalidURL(t *testing.T) {
+	for _, rawURL := range []string{"https://127.0.0.1:8484", "http://broker.example.com", "http://10.0.0.1:8484", "unix://", "tcp://127.0.0.1:8484"} {
+		if _, err := newBrokerClient(BrokerConfig{URL: rawURL}); err == nil {
+			t.Errorf("expected %q to be rejected", rawURL)
+		}
+	}
+}
+
+func TestBroker_UnixSocketPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on Linux")
+	}
+
+	// Socket paths are limited to about 100 bytes, too short for t.TempDir()
+	dir, err := os.MkdirTemp("", "broker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "broker.sock")
+
+	// A stale socket file left by a broker that was killed is replaced
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := ListenBroker("unix:" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newTestBroker(t, BrokerOptions{Registries: []string{"myregistry.azurecr.io"}, UIDs: []int{os.Getuid()}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- ServeBroker(ctx, listener, handler) }()
+
+	if _, err := ListenBroker("unix:" + socket); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("expected running broker to be detected, got: %v", err)
+	}
+
+	client, err := newBrokerClient(BrokerConfig{URL: "unix://" + socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, secret, err := client.Credentials("myregistry.azurecr.io")
+	if err != nil || username != ACRRefreshTokenUsername || secret != "fake-refresh-token-12345" {
+		t.Errorf("expected credentials for the peer user, got %s/%s, %v", username, secret, err)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("expected clean shutdown, got: %v", err)
+	}
+}
@@ -0,0 +1,297 @@
+package acr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token cache backends
+const (
+	CacheBackendNone    = "none"
+	CacheBackendMemory  = "memory"
+	CacheBackendFile    = "file"
+	CacheBackendKeyring = "keyring"
+)
+
+// Keys for the encrypted file backend
+const (
+	CacheKeyMachine    = "machine"
+	CacheKeyPassphrase = "passphrase"
+)
+
+// Cached refresh tokens are reused only while valid for at least this long
+const cacheExpiryMargin = 5 * time.Minute
+
+// How long admin credentials read from ARM are cached
+const adminCredentialsCacheTTL = time.Hour
+
+// cacheWarnings receives the warning printed when the keyring backend falls
+// back to another one; stdout carries the credential helper protocol
+var cacheWarnings io.Writer = os.Stderr
+
+var (
+	// ErrCacheMiss is returned by CacheStore.Get for missing or expired entries
+	ErrCacheMiss = errors.New("cache miss")
+
+	// ErrCacheTampered is returned by CacheStore.Get for entries that fail
+	// authentication; the entry is discarded
+	ErrCacheTampered = errors.New("cache entry failed integrity check")
+)
+
+// CacheConfig selects where the helper caches ACR refresh tokens. The zero
+// value disables caching.
+type CacheConfig struct {
+	// Backend is none, memory, file or keyring. The keyring is the Linux
+	// kernel keyring (not the Secret Service); when it is unavailable the
+	// encrypted file, then memory is used with a warning on stderr.
+	Backend string `json:"backend,omitempty"`
+
+	// Dir holds the encrypted file cache (default: <user cache dir>/docker-credential-acr)
+	Dir string `json:"dir,omitempty"`
+
+	// Key is "machine" (derived from the machine ID, the default) or
+	// "passphrase" (read from DOCKER_CREDENTIAL_ACR_CACHE_PASSPHRASE)
+	Key string `json:"key,omitempty"`
+}
+
+// CacheStore stores opaque values with an expiry
+type CacheStore interface {
+	// Get returns the value stored under key, ErrCacheMiss when there is
+	// none, or ErrCacheTampered when it was modified
+	Get(key string) ([]byte, error)
+
+	// Set stores value under key until ttl elapses
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Delete removes key; deleting a missing key is not an error
+	Delete(key string) error
+
+	// Clear removes all entries
+	Clear() error
+}
+
+// NewCacheStore creates the configured cache backend; it returns nil when
+// caching is disabled
+func NewCacheStore(cfg CacheConfig) (CacheStore, error) {
+	switch cfg.Backend {
+	case "", CacheBackendNone:
+		return nil, nil
+	case CacheBackendMemory:
+		return NewMemoryCacheStore(), nil
+	case CacheBackendFile:
+		return newFileCacheStore(cfg)
+	case CacheBackendKeyring:
+		store, err := newKeyringCacheStore()
+		if err == nil {
+			return store, nil
+		}
+		// Never fall back to plain storage: the encrypted file needs a key,
+		// without one tokens are only kept in memory
+		fileStore, fileErr := newFileCacheStore(cfg)
+		if fileErr == nil {
+			fmt.Fprintf(cacheWarnings, "docker-credential-acr: warning: %v; caching tokens in the encrypted file cache\n", err)
+			return fileStore, nil
+		}
+		fmt.Fprintf(cacheWarnings, "docker-credential-acr: warning: %v, file cache unavailable: %v; caching tokens in memory for this invocation only\n", err, fileErr)
+		return NewMemoryCacheStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported cache backend %q: must be none, memory, file or keyring", cfg.Backend)
+	}
+}
+
+// memoryCacheStore keeps entries for the lifetime of the process
+type memoryCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemoryCacheStore creates an in-memory cache store
+func NewMemoryCacheStore() CacheStore {
+	return &memoryCacheStore{entries: map[string]memoryCacheEntry{}}
+}
+
+func (m *memoryCacheStore) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, ErrCacheMiss
+	}
+	return entry.value, nil
+}
+
+func (m *memoryCacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memoryCacheEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryCacheStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memoryCacheStore) Clear() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.entries)
+	return nil
+}
+
+// cachedCredentials is the cached form of registry credentials
+type cachedCredentials struct {
+	Username  string    `json:"username"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// refreshTokenCacheKey identifies the refresh token of a registry. The
+// credential identity keeps tokens of different credentials and accounts
+// apart; it is hashed so keys do not reveal account names.
+func (h *ACRHelper) refreshTokenCacheKey(registryHost string) string {
+	identity := sha256.Sum256([]byte(h.credentialIdentity()))
+	return strings.Join([]string{
+		"refresh-token",
+		registryHost,
+		h.config.Registry(registryHost).Tenant,
+		hex.EncodeToString(identity[:16]),
+	}, "/")
+}
+
+// credentialIdentity names the source of refresh tokens: the broker or the
+// authenticator's credential and account
+func (h *ACRHelper) credentialIdentity() string {
+	if h.broker != nil {
+		return "broker/" + h.broker.url
+	}
+	if identifier, ok := h.authenticator.(CredentialIdentifier); ok {
+		return identifier.CredentialIdentity()
+	}
+	return ""
+}
+
+// cachedRefreshToken returns a cached refresh token that is still valid for
+// at least minValidity
+func (h *ACRHelper) cachedRefreshToken(registryHost string, minValidity time.Duration) (string, string, bool) {
+	if h.cache == nil {
+		return "", "", false
+	}
+
+	key := h.refreshTokenCacheKey(registryHost)
+	data, err := h.cache.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrCacheTampered) {
+			_ = h.cache.Delete(key)
+		}
+		return "", "", false
+	}
+
+	var creds cachedCredentials
+	if err := json.Unmarshal(data, &creds); err != nil || creds.Secret == "" {
+		_ = h.cache.Delete(key)
+		return "", "", false
+	}
+	if time.Until(creds.ExpiresAt) < max(minValidity, cacheExpiryMargin) {
+		return "", "", false
+	}
+
+	return creds.Username, creds.Secret, true
+}
+
+// adminCredentialsCacheKey identifies the admin credentials of a registry
+// read with the current identity
+func (h *ACRHelper) adminCredentialsCacheKey(registryHost string) string {
+	return "admin/" + h.refreshTokenCacheKey(registryHost)
+}
+
+// cachedAdminCredentials returns cached admin credentials of the registry
+func (h *ACRHelper) cachedAdminCredentials(registryHost string) (string, string, bool) {
+	if h.cache == nil {
+		return "", "", false
+	}
+
+	key := h.adminCredentialsCacheKey(registryHost)
+	data, err := h.cache.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrCacheTampered) {
+			_ = h.cache.Delete(key)
+		}
+		return "", "", false
+	}
+
+	var creds cachedCredentials
+	if err := json.Unmarshal(data, &creds); err != nil || creds.Username == "" || creds.Secret == "" {
+		_ = h.cache.Delete(key)
+		return "", "", false
+	}
+	return creds.Username, creds.Secret, true
+}
+
+// cacheAdminCredentials stores admin credentials for adminCredentialsCacheTTL.
+// Unlike refresh tokens they do not expire, so the TTL bounds how long a
+// regenerated password goes unnoticed.
+func (h *ACRHelper) cacheAdminCredentials(registryHost, username, password string) {
+	if h.cache == nil {
+		return
+	}
+
+	data, err := json.Marshal(cachedCredentials{Username: username, Secret: password, ExpiresAt: time.Now().Add(adminCredentialsCacheTTL)})
+	if err != nil {
+		return
+	}
+	_ = h.cache.Set(h.adminCredentialsCacheKey(registryHost), data, adminCredentialsCacheTTL)
+}
+
+// forgetAdminCredentials removes cached admin credentials the registry
+// rejected, so the next request reads them from ARM again
+func (h *ACRHelper) forgetAdminCredentials(registryHost string) {
+	if h.cache != nil {
+		_ = h.cache.Delete(h.adminCredentialsCacheKey(registryHost))
+	}
+}
+
+// cacheRefreshToken stores a refresh token until its 'exp' claim or, if
+// earlier, the expiry reported by its source (zero if none). Tokens without
+// any known expiry are not cached.
+func (h *ACRHelper) cacheRefreshToken(registryHost, username, secret string, reportedExpiry time.Time) {
+	if h.cache == nil {
+		return
+	}
+
+	expiresAt, err := TokenExpiry(secret)
+	switch {
+	case err != nil:
+		expiresAt = reportedExpiry
+	case !reportedExpiry.IsZero() && reportedExpiry.Before(expiresAt):
+		expiresAt = reportedExpiry
+	}
+	if time.Until(expiresAt) < cacheExpiryMargin {
+		return
+	}
+
+	data, err := json.Marshal(cachedCredentials{Username: username, Secret: secret, ExpiresAt: expiresAt})
+	if err != nil {
+		return
+	}
+
+	// Caching is best effort; a failing store only costs another exchange
+	_ = h.cache.Set(h.refreshTokenCacheKey(registryHost), data, time.Until(expiresAt))
+}
@@ -0,0 +1,239 @@
+package acr
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Passphrase for the encrypted file cache
+const EnvCachePassphrase = "DOCKER_CREDENTIAL_ACR_CACHE_PASSPHRASE" // #nosec G101
+
+const (
+	cacheFileVersion    = 1
+	cacheSaltFile       = "salt"
+	cacheSaltSize       = 32
+	cachePBKDF2Rounds   = 200_000
+	cacheEntrySuffix    = ".bin"
+	cacheAuthDataPrefix = "docker-credential-acr cache v1:"
+)
+
+// Machine ID locations, read in order
+var machineIDFiles = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// fileCacheStore keeps every entry in its own AES-256-GCM encrypted file.
+// The cache key is bound to the ciphertext as additional data, so entries
+// cannot be modified or swapped without failing authentication.
+type fileCacheStore struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+func newFileCacheStore(cfg CacheConfig) (CacheStore, error) {
+	dir, err := cacheDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := loadCacheSalt(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := deriveCacheKey(cfg.Key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileCacheStore{dir: dir, aead: aead}, nil
+}
+
+// cacheDir returns the configured cache directory, creating it if needed
+func cacheDir(cfg CacheConfig) (string, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to determine cache directory: %w", err)
+		}
+		dir = filepath.Join(userCacheDir, "docker-credential-acr")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return dir, nil
+}
+
+// loadCacheSalt reads the per-directory salt, creating it on first use
+func loadCacheSalt(dir string) ([]byte, error) {
+	path := filepath.Join(dir, cacheSaltFile)
+
+	salt, err := os.ReadFile(filepath.Clean(path))
+	if err == nil && len(salt) == cacheSaltSize {
+		return salt, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read cache salt: %w", err)
+	}
+
+	// A truncated salt starts a new cache; old entries then fail
+	// authentication and are discarded
+	if err == nil {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to replace cache salt: %w", err)
+		}
+	}
+
+	salt = make([]byte, cacheSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	// Publish the salt with a hard link so concurrent helpers agree on the
+	// first one written instead of overwriting each other's
+	tmp := path + ".tmp-" + strconv.Itoa(os.Getpid())
+	if err := os.WriteFile(tmp, salt, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write cache salt: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, path); errors.Is(err, fs.ErrExist) {
+		existing, err := os.ReadFile(filepath.Clean(path))
+		if err != nil || len(existing) != cacheSaltSize {
+			return nil, fmt.Errorf("invalid cache salt %s", path)
+		}
+		return existing, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to write cache salt: %w", err)
+	}
+
+	return salt, nil
+}
+
+// deriveCacheKey derives the AES-256 key from the machine ID and user, or
+// from the user's passphrase
+func deriveCacheKey(keySource string, salt []byte) ([]byte, error) {
+	switch keySource {
+	case "", CacheKeyMachine:
+		machineID, err := readMachineID()
+		if err != nil {
+			return nil, err
+		}
+		info := cacheAuthDataPrefix + "uid=" + strconv.Itoa(os.Getuid())
+		return hkdf.Key(sha256.New, machineID, salt, info, 32)
+	case CacheKeyPassphrase:
+		passphrase := os.Getenv(EnvCachePassphrase)
+		if passphrase == "" {
+			return nil, fmt.Errorf("cache key %q requires %s", keySource, EnvCachePassphrase)
+		}
+		return pbkdf2.Key(sha256.New, passphrase, salt, cachePBKDF2Rounds, 32)
+	default:
+		return nil, fmt.Errorf("unsupported cache key %q: must be machine or passphrase", keySource)
+	}
+}
+
+func readMachineID() ([]byte, error) {
+	for _, path := range machineIDFiles {
+		if id, err := os.ReadFile(path); err == nil {
+			if id = bytes.TrimSpace(id); len(id) > 0 {
+				return id, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no machine ID found; use a passphrase key via %s", EnvCachePassphrase)
+}
+
+// entryPath maps a cache key to a file name that does not reveal the key
+func (f *fileCacheStore) entryPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+cacheEntrySuffix)
+}
+
+// Entry layout: version (1 byte) | nonce | ciphertext of expiry (8 bytes,
+// unix seconds) followed by the value
+func (f *fileCacheStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(f.entryPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+
+	nonceSize := f.aead.NonceSize()
+	if len(data) < 1+nonceSize || data[0] != cacheFileVersion {
+		return nil, ErrCacheTampered
+	}
+
+	plaintext, err := f.aead.Open(nil, data[1:1+nonceSize], data[1+nonceSize:], []byte(cacheAuthDataPrefix+key))
+	if err != nil || len(plaintext) < 8 {
+		return nil, ErrCacheTampered
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(plaintext[:8])), 0) // #nosec G115 -- written by Set
+	if !time.Now().Before(expiresAt) {
+		_ = f.Delete(key)
+		return nil, ErrCacheMiss
+	}
+
+	return plaintext[8:], nil
+}
+
+func (f *fileCacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	plaintext := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(plaintext, uint64(time.Now().Add(ttl).Unix())) // #nosec G115 -- positive timestamp
+	plaintext = append(plaintext, value...)
+
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data := append([]byte{cacheFileVersion}, nonce...)
+	data = f.aead.Seal(data, nonce, plaintext, []byte(cacheAuthDataPrefix+key))
+
+	return WriteFileAtomic(f.entryPath(key), data, 0o600)
+}
+
+func (f *fileCacheStore) Delete(key string) error {
+	if err := os.Remove(f.entryPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove cache entry: %w", err)
+	}
+	return nil
+}
+
+// Clear removes all entry files; the salt is kept
+func (f *fileCacheStore) Clear() error {
+	entries, err := filepath.Glob(filepath.Join(f.dir, "*"+cacheEntrySuffix))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Remove(entry); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove cache entry: %w", err)
+		}
+	}
+	return nil
+}
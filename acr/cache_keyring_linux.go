@@ -0,0 +1,124 @@
+//go:build linux
+
+package acr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Prefix of key descriptions in the kernel keyring
+const keyringDescriptionPrefix = "docker-credential-acr:"
+
+// keyringCacheStore keeps entries as "user" keys in the Linux kernel user
+// keyring. Entries never touch the disk, are only readable by the user and
+// expire through the kernel's key timeout.
+type keyringCacheStore struct {
+	ringID int
+}
+
+func newKeyringCacheStore() (CacheStore, error) {
+	// Fails where keyctl is unavailable, e.g. under the default container
+	// seccomp profile
+	ringID, err := unix.KeyctlGetKeyringID(unix.KEY_SPEC_USER_KEYRING, true)
+	if err != nil {
+		return nil, fmt.Errorf("kernel keyring unavailable: %w", err)
+	}
+	return &keyringCacheStore{ringID: ringID}, nil
+}
+
+func (k *keyringCacheStore) search(key string) (int, error) {
+	id, err := unix.KeyctlSearch(k.ringID, "user", keyringDescriptionPrefix+key, 0)
+	if errors.Is(err, unix.ENOKEY) || errors.Is(err, unix.EKEYEXPIRED) || errors.Is(err, unix.EKEYREVOKED) {
+		return 0, ErrCacheMiss
+	}
+	return id, err
+}
+
+func (k *keyringCacheStore) Get(key string) ([]byte, error) {
+	id, err := k.search(key)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, ErrCacheMiss
+	}
+	value := make([]byte, size)
+	if _, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, value, 0); err != nil {
+		return nil, ErrCacheMiss
+	}
+
+	return value, nil
+}
+
+func (k *keyringCacheStore) Set(key string, value []byte, ttl time.Duration) error {
+	id, err := unix.AddKey("user", keyringDescriptionPrefix+key, value, k.ringID)
+	if err != nil {
+		return fmt.Errorf("failed to add key to keyring: %w", err)
+	}
+
+	seconds := int(min(math.Ceil(ttl.Seconds()), math.MaxInt32))
+	if _, err := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, id, seconds, 0, 0); err != nil {
+		_ = k.Delete(key)
+		return fmt.Errorf("failed to set key timeout: %w", err)
+	}
+
+	return nil
+}
+
+func (k *keyringCacheStore) Delete(key string) error {
+	id, err := k.search(key)
+	if errors.Is(err, ErrCacheMiss) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, k.ringID, 0, 0); err != nil {
+		return fmt.Errorf("failed to remove key from keyring: %w", err)
+	}
+	return nil
+}
+
+// Clear unlinks the helper's keys from the keyring; other keys are left alone
+func (k *keyringCacheStore) Clear() error {
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, k.ringID, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to read keyring: %w", err)
+	}
+	ids := make([]byte, size)
+	if size, err = unix.KeyctlBuffer(unix.KEYCTL_READ, k.ringID, ids, 0); err != nil {
+		return fmt.Errorf("failed to read keyring: %w", err)
+	}
+	ids = ids[:min(size, len(ids))]
+
+	var errs []error
+	for i := 0; i+4 <= len(ids); i += 4 {
+		id := int(int32(binary.NativeEndian.Uint32(ids[i:]))) // #nosec G115 -- key serial numbers are int32
+
+		// Descriptions read "<type>;<uid>;<gid>;<perm>;<description>"; keys
+		// that expired meanwhile can no longer be described
+		description, err := unix.KeyctlString(unix.KEYCTL_DESCRIBE, id)
+		if err != nil {
+			continue
+		}
+		fields := strings.SplitN(description, ";", 5)
+		if len(fields) != 5 || fields[0] != "user" || !strings.HasPrefix(fields[4], keyringDescriptionPrefix) {
+			continue
+		}
+
+		if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, k.ringID, 0, 0); err != nil && !errors.Is(err, unix.ENOKEY) {
+			errs = append(errs, fmt.Errorf("failed to remove key from keyring: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
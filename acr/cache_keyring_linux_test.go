@@ -0,0 +1,44 @@
+//go:build linux
+
+package acr
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyringCacheStore(t *testing.T) {
+	store, err := newKeyringCacheStore()
+	if err != nil {
+		t.Skipf("kernel keyring unavailable: %v", err)
+	}
+
+	key := "test/" + t.Name() + "/" + time.Now().Format(time.RFC3339Nano)
+	t.Cleanup(func() { store.Delete(key) })
+
+	if err := store.Set(key, []byte("value"), time.Hour); err != nil {
+		t.Skipf("kernel keyring not writable: %v", err)
+	}
+	value, err := store.Get(key)
+	if err != nil || string(value) != "value" {
+		t.Errorf("expected value, got %q, %v", value, err)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("expected deleted key to miss, got: %v", err)
+	}
+
+	if err := store.Set(key, []byte("value"), time.Hour); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := store.Clear(); err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("expected cleared key to miss, got: %v", err)
+	}
+}
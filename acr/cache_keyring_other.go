@@ -0,0 +1,9 @@
+//go:build !linux
+
+package acr
+
+import "fmt"
+
+func newKeyringCacheStore() (CacheStore, error) {
+	return nil, fmt.Errorf("kernel keyring is only supported on Linux")
+}
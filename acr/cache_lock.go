@@ -0,0 +1,187 @@
+package acr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How long a process waits for another one to fetch a token before
+	// fetching it independently
+	cacheLockTimeout = 15 * time.Second
+
+	// Locks older than this are abandoned; a fetch takes at most one Azure
+	// AD request and one exchange
+	cacheLockStaleAfter = 2 * TokenRequestTimeout
+
+	cacheLockPollInterval = 50 * time.Millisecond
+	cacheLockSuffix       = ".lock"
+)
+
+// cacheLocker serializes token acquisition for a cache key across helper
+// processes with advisory lock files. When a CI job starts dozens of helpers
+// for one registry, the first fetches and caches the token while the others
+// wait and then read it from the shared cache.
+type cacheLocker struct {
+	dir          string
+	timeout      time.Duration
+	staleAfter   time.Duration
+	pollInterval time.Duration
+}
+
+// newCacheLocker creates lock files in the cache directory
+func newCacheLocker(cfg CacheConfig) (*cacheLocker, error) {
+	dir, err := cacheDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheLocker{
+		dir:          dir,
+		timeout:      cacheLockTimeout,
+		staleAfter:   cacheLockStaleAfter,
+		pollInterval: cacheLockPollInterval,
+	}, nil
+}
+
+// lockPath maps a cache key to its lock file
+func (l *cacheLocker) lockPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(l.dir, hex.EncodeToString(sum[:])+cacheLockSuffix)
+}
+
+// tryLock creates the lock file for key. It returns false when another
+// process holds the lock.
+func (l *cacheLocker) tryLock(key string) (unlock func(), ok bool, err error) {
+	path := l.lockPath(key)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) // #nosec G304 -- path derived from a hash
+	if errors.Is(err, fs.ErrExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create lock file: %w", err)
+	}
+
+	owner := lockOwner()
+	_, err = file.WriteString(owner)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, false, fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	return func() {
+		// Leave the lock alone if it was broken and taken over meanwhile
+		if data, err := os.ReadFile(filepath.Clean(path)); err == nil && string(data) == owner {
+			_ = os.Remove(path)
+		}
+	}, true, nil
+}
+
+// breakStale removes the lock for key if it is older than staleAfter or its
+// owner is a process on this host that no longer exists
+func (l *cacheLocker) breakStale(key string) {
+	path := l.lockPath(key)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return
+	}
+
+	if time.Since(info.ModTime()) < l.staleAfter && !ownerExited(string(data)) {
+		return
+	}
+
+	// Only remove the lock that was judged stale, not a new one
+	if current, err := os.ReadFile(filepath.Clean(path)); err == nil && string(current) == string(data) {
+		_ = os.Remove(path)
+	}
+}
+
+// lockOwner identifies this process in a lock file as "<pid> <hostname>
+// <time>"; the time tells locks of a reused PID apart
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+	return strconv.Itoa(os.Getpid()) + " " + hostname + " " + strconv.FormatInt(time.Now().UnixNano(), 10) + "\n"
+}
+
+// ownerExited reports whether the lock owner is known to have exited. Owners
+// on other hosts (shared cache directories) are only expired by age.
+func ownerExited(owner string) bool {
+	fields := strings.Fields(owner)
+	if len(fields) < 2 {
+		return false
+	}
+	hostname, _ := os.Hostname()
+	if fields[1] != hostname {
+		return false
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return false
+	}
+	return !processExists(pid)
+}
+
+// awaitRefreshToken takes the lock for a registry's refresh token. While
+// another process holds it, the cache is polled for the token that process
+// fetches, or the failure it caches. ok is true when either was found;
+// otherwise the caller fetches the token and calls unlock afterwards. After
+// the timeout the caller fetches independently.
+func (h *ACRHelper) awaitRefreshToken(registryHost string, minValidity time.Duration) (unlock func(), username, secret string, ok bool, err error) {
+	key := h.refreshTokenCacheKey(registryHost)
+	deadline := time.Now().Add(h.locks.timeout)
+
+	for {
+		release, locked, err := h.locks.tryLock(key)
+		if err != nil {
+			// Locking is best effort
+			return func() {}, "", "", false, nil
+		}
+		if locked {
+			// The previous holder may have finished just now
+			if username, secret, ok, err := h.awaitedResult(registryHost, minValidity); ok {
+				release()
+				return nil, username, secret, true, err
+			}
+			return release, "", "", false, nil
+		}
+
+		if username, secret, ok, err := h.awaitedResult(registryHost, minValidity); ok {
+			return nil, username, secret, true, err
+		}
+
+		if !time.Now().Before(deadline) {
+			return func() {}, "", "", false, nil
+		}
+
+		h.locks.breakStale(key)
+		time.Sleep(h.locks.pollInterval)
+	}
+}
+
+// awaitedResult returns what the lock holder left in the cache: a refresh
+// token, or a failure that waiting processes report instead of repeating it
+func (h *ACRHelper) awaitedResult(registryHost string, minValidity time.Duration) (username, secret string, ok bool, err error) {
+	if username, secret, ok := h.cachedRefreshToken(registryHost, minValidity); ok {
+		return username, secret, true, nil
+	}
+	if err := h.cachedFailureError(registryHost); err != nil {
+		return "", "", true, err
+	}
+	return "", "", false, nil
+}
@@ -0,0 +1,270 @@
+package acr
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLocker(t *testing.T) *cacheLocker {
+	t.Helper()
+	return &cacheLocker{
+		dir:          t.TempDir(),
+		timeout:      5 * time.Second,
+		staleAfter:   time.Minute,
+		pollInterval: 5 * time.Millisecond,
+	}
+}
+
+func TestCacheLocker_TryLock(t *testing.T) {
+	locks := newTestLocker(t)
+
+	unlock, ok, err := locks.tryLock("key")
+	if err != nil || !ok {
+		t.Fatalf("expected lock, got %v, %v", ok, err)
+	}
+	if _, ok, err := locks.tryLock("key"); ok || err != nil {
+		t.Fatalf("expected lock to be held, got %v, %v", ok, err)
+	}
+	if _, ok, _ := locks.tryLock("other"); !ok {
+		t.Error("expected locks of other keys to be independent")
+	}
+
+	unlock()
+	if _, err := os.Stat(locks.lockPath("key")); !os.IsNotExist(err) {
+		t.Errorf("expected lock file to be removed, got: %v", err)
+	}
+	if _, ok, _ := locks.tryLock("key"); !ok {
+		t.Error("expected lock to be free after unlock")
+	}
+}
+
+func TestCacheLocker_UnlockKeepsForeignLock(t *testing.T) {
+	locks := newTestLocker(t)
+
+	unlock, _, _ := locks.tryLock("key")
+
+	// The lock was judged stale and taken over by another process
+	if err := os.WriteFile(locks.lockPath("key"), []byte("1 otherhost 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if _, err := os.Stat(locks.lockPath("key")); err != nil {
+		t.Errorf("expected the other process's lock to be kept, got: %v", err)
+	}
+}
+
+func TestCacheLocker_BreakStale(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name  string
+		owner string
+		age   time.Duration
+		stale bool
+	}{
+		{"live owner", lockOwner(), 0, false},
+		{"other host", "1 some-other-host 1\n", 0, false},
+		{"expired", lockOwner(), 2 * time.Minute, true},
+		{"expired on other host", "1 some-other-host 1\n", 2 * time.Minute, true},
+		{"garbage", "garbage", 0, false},
+	}
+
+	if runtime.GOOS != "windows" {
+		cmd := exec.Command("true")
+		if err := cmd.Run(); err == nil {
+			owner := strconv.Itoa(cmd.Process.Pid) + " " + hostname + " 1\n"
+			tests = append(tests, struct {
+				name  string
+				owner string
+				age   time.Duration
+				stale bool
+			}{"exited owner", owner, 0, true})
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locks := newTestLocker(t)
+			path := locks.lockPath("key")
+			if err := os.WriteFile(path, []byte(tt.owner), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			modified := time.Now().Add(-tt.age)
+			if err := os.Chtimes(path, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+
+			locks.breakStale("key")
+
+			_, err := os.Stat(path)
+			if broken := os.IsNotExist(err); broken != tt.stale {
+				t.Errorf("expected stale=%v, lock removed=%v", tt.stale, broken)
+			}
+		})
+	}
+}
+
+func TestGet_WaitsForLockHolderToFillCache(t *testing.T) {
+	withMachineID(t, "0123456789abcdef")
+	dir := t.TempDir()
+
+	refreshToken := makeToken(t, time.Now().Add(3*time.Hour))
+	newProcess := func() (*ACRHelper, *countingAuthenticator) {
+		auth := &countingAuthenticator{fakeAuthenticator: *successAuthenticator()}
+		helper := NewACRHelperWithAuthenticator(auth)
+		store, err := newFileCacheStore(CacheConfig{Dir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		helper.cache = store
+		helper.locks = &cacheLocker{dir: dir, timeout: 5 * time.Second, staleAfter: time.Minute, pollInterval: 5 * time.Millisecond}
+		return helper, auth
+	}
+
+	first, _ := newProcess()
+	second, secondAuth := newProcess()
+
+	// The first process holds the lock while fetching
+	unlock, ok, err := first.locks.tryLock(first.refreshTokenCacheKey("myregistry.azurecr.io"))
+	if err != nil || !ok {
+		t.Fatalf("expected lock, got %v, %v", ok, err)
+	}
+
+	type result struct {
+		secret string
+		err    error
+	}
+	done := make(chan result)
+	go func() {
+		_, secret, err := second.Get("myregistry.azurecr.io")
+		done <- result{secret, err}
+	}()
+
+	select {
+	case r := <-done:
+		t.Fatalf("expected Get to wait for the lock holder, got %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first.cacheRefreshToken("myregistry.azurecr.io", ACRRefreshTokenUsername, refreshToken, time.Time{})
+	unlock()
+
+	r := <-done
+	if r.err != nil || r.secret != refreshToken {
+		t.Fatalf("expected cached refresh token, got %+v", r)
+	}
+	if got := secondAuth.exchangeCalls.Load(); got != 0 {
+		t.Errorf("expected waiting process not to exchange, got %d exchanges", got)
+	}
+}
+
+func TestGet_WaiterReturnsLockHoldersFailure(t *testing.T) {
+	auth := &countingAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.cache = NewMemoryCacheStore()
+	helper.failures = helper.cache
+	helper.failureTTL = time.Minute
+	helper.locks = newTestLocker(t)
+
+	// Another process holds the lock while fetching
+	const registry = "myregistry.azurecr.io"
+	if _, ok, _ := helper.locks.tryLock(helper.refreshTokenCacheKey(registry)); !ok {
+		t.Fatal("expected lock")
+	}
+
+	done := make(chan error)
+	go func() {
+		_, _, err := helper.Get(registry)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected Get to wait for the lock holder, got: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The lock holder fails and keeps the lock until it exits
+	helper.recordFailure(registry, &ExchangeStatusError{StatusCode: 403, Body: "denied"})
+
+	select {
+	case err := <-done:
+		var cached *CachedFailureError
+		if !errors.As(err, &cached) || !strings.Contains(err.Error(), "status 403") {
+			t.Fatalf("expected the lock holder's failure, got: %v", err)
+		}
+	case <-time.After(helper.locks.timeout / 2):
+		t.Fatal("expected the waiter to return the failure before the lock timeout")
+	}
+	if got := auth.exchangeCalls.Load(); got != 0 {
+		t.Errorf("expected no exchange, got %d", got)
+	}
+}
+
+func TestGet_LockTimeoutFallsBackToFetching(t *testing.T) {
+	auth := &countingAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.cache = NewMemoryCacheStore()
+	helper.locks = newTestLocker(t)
+	helper.locks.timeout = 50 * time.Millisecond
+
+	// A live process holds the lock but never fills the cache
+	if _, ok, _ := helper.locks.tryLock(helper.refreshTokenCacheKey("myregistry.azurecr.io")); !ok {
+		t.Fatal("expected lock")
+	}
+
+	start := time.Now()
+	if _, secret, err := helper.Get("myregistry.azurecr.io"); err != nil || secret != "token-for-myregistry.azurecr.io" {
+		t.Fatalf("expected independent fetch, got %q, %v", secret, err)
+	}
+	if elapsed := time.Since(start); elapsed < helper.locks.timeout {
+		t.Errorf("expected Get to wait for the lock first, returned after %v", elapsed)
+	}
+	if got := auth.exchangeCalls.Load(); got != 1 {
+		t.Errorf("expected 1 exchange, got %d", got)
+	}
+}
+
+func TestGet_ReleasesLockAfterFailure(t *testing.T) {
+	auth := &fakeAuthenticator{accessToken: "azure-token", tenantID: "tenant", refreshTokenErr: errors.New("401")}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.cache = NewMemoryCacheStore()
+	helper.locks = newTestLocker(t)
+
+	if _, _, err := helper.Get("myregistry.azurecr.io"); err == nil {
+		t.Fatal("expected exchange error")
+	}
+	if _, err := os.Stat(helper.locks.lockPath(helper.refreshTokenCacheKey("myregistry.azurecr.io"))); !os.IsNotExist(err) {
+		t.Errorf("expected lock to be released, got: %v", err)
+	}
+}
+
+func TestNewACRHelperWithConfig_LocksSharedCachesOnly(t *testing.T) {
+	withMachineID(t, "0123456789abcdef")
+	t.Setenv(EnvAuthRecord, filepath.Join(t.TempDir(), "authentication-record.json"))
+
+	dir := t.TempDir()
+	helper, err := NewACRHelperWithConfig(&Config{Cache: CacheConfig{Backend: CacheBackendFile, Dir: dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if helper.locks == nil || helper.locks.dir != dir {
+		t.Errorf("expected locks in the cache directory, got %+v", helper.locks)
+	}
+
+	helper, err = NewACRHelperWithConfig(&Config{Cache: CacheConfig{Backend: CacheBackendMemory}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if helper.locks != nil {
+		t.Error("expected no locks for the in-memory cache")
+	}
+}
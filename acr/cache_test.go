@@ -0,0 +1,316 @@
+package acr
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// withMachineID points the machine ID lookup at a temporary file
+func withMachineID(t *testing.T, id string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "machine-id")
+	if err := os.WriteFile(path, []byte(id+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	original := machineIDFiles
+	machineIDFiles = []string{path}
+	t.Cleanup(func() { machineIDFiles = original })
+}
+
+func testCacheStore(t *testing.T, store CacheStore) {
+	t.Helper()
+
+	if _, err := store.Get("missing"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("expected cache miss, got: %v", err)
+	}
+
+	if err := store.Set("key", []byte("value"), time.Hour); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	value, err := store.Get("key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("expected value, got %q, %v", value, err)
+	}
+
+	if err := store.Set("expired", []byte("value"), -time.Second); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if _, err := store.Get("expired"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("expected expired entry to miss, got: %v", err)
+	}
+
+	if err := store.Delete("key"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := store.Get("key"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("expected deleted entry to miss, got: %v", err)
+	}
+	if err := store.Delete("key"); err != nil {
+		t.Errorf("deleting a missing key must not fail: %v", err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if err := store.Set(key, []byte("value"), time.Hour); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+	if err := store.Clear(); err != nil {
+		t.Fatalf("clear failed: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := store.Get(key); !errors.Is(err, ErrCacheMiss) {
+			t.Errorf("expected %s to be cleared, got: %v", key, err)
+		}
+	}
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	testCacheStore(t, NewMemoryCacheStore())
+}
+
+func TestFileCacheStore(t *testing.T) {
+	withMachineID(t, "0123456789abcdef")
+
+	store, err := newFileCacheStore(CacheConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	testCacheStore(t, store)
+}
+
+func TestFileCacheStore_TamperDetection(t *testing.T) {
+	withMachineID(t, "0123456789abcdef")
+	dir := t.TempDir()
+
+	store, err := newFileCacheStore(CacheConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	fileStore := store.(*fileCacheStore)
+
+	if err := store.Set("a", []byte("secret-a"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("b", []byte("secret-b"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(fileStore.entryPath("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) == "secret-a" || len(data) < 20 {
+		t.Fatalf("expected encrypted entry, got %q", data)
+	}
+
+	// Swapping entries must not go unnoticed
+	if err := os.WriteFile(fileStore.entryPath("b"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("b"); !errors.Is(err, ErrCacheTampered) {
+		t.Errorf("expected swapped entry to be detected, got: %v", err)
+	}
+
+	// Flipping a ciphertext bit must fail authentication
+	data[len(data)-1] ^= 0x01
+	if err := os.WriteFile(fileStore.entryPath("a"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("a"); !errors.Is(err, ErrCacheTampered) {
+		t.Errorf("expected modified entry to be detected, got: %v", err)
+	}
+}
+
+func TestFileCacheStore_KeyBinding(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(EnvCachePassphrase, "correct horse")
+
+	store, err := newFileCacheStore(CacheConfig{Dir: dir, Key: CacheKeyPassphrase})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if err := store.Set("key", []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same passphrase and directory (salt) decrypts
+	reopened, err := newFileCacheStore(CacheConfig{Dir: dir, Key: CacheKeyPassphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := reopened.Get("key"); err != nil || string(value) != "value" {
+		t.Errorf("expected value after reopening, got %q, %v", value, err)
+	}
+
+	t.Setenv(EnvCachePassphrase, "battery staple")
+	other, err := newFileCacheStore(CacheConfig{Dir: dir, Key: CacheKeyPassphrase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get("key"); !errors.Is(err, ErrCacheTampered) {
+		t.Errorf("expected other passphrase to fail, got: %v", err)
+	}
+
+	t.Setenv(EnvCachePassphrase, "")
+	if _, err := newFileCacheStore(CacheConfig{Dir: dir, Key: CacheKeyPassphrase}); err == nil {
+		t.Error("expected error without passphrase")
+	}
+}
+
+func TestNewCacheStore(t *testing.T) {
+	if store, err := NewCacheStore(CacheConfig{}); store != nil || err != nil {
+		t.Errorf("expected caching to be disabled by default, got %T, %v", store, err)
+	}
+	if _, err := NewCacheStore(CacheConfig{Backend: "redis"}); err == nil {
+		t.Error("expected error for unsupported backend")
+	}
+
+	// Without keyring and machine ID the keyring backend degrades to memory
+	// (never to an unencrypted file)
+	original := machineIDFiles
+	machineIDFiles = []string{filepath.Join(t.TempDir(), "missing")}
+	t.Cleanup(func() { machineIDFiles = original })
+
+	var warnings strings.Builder
+	cacheWarnings = &warnings
+	t.Cleanup(func() { cacheWarnings = os.Stderr })
+
+	store, err := NewCacheStore(CacheConfig{Backend: CacheBackendKeyring, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("expected fallback, got: %v", err)
+	}
+	if _, ok := store.(*fileCacheStore); ok || store == nil {
+		t.Errorf("unexpected fallback store %T", store)
+	}
+	if _, ok := store.(*memoryCacheStore); ok && !strings.Contains(warnings.String(), "caching tokens in memory") {
+		t.Errorf("expected a warning naming the memory backend, got %q", warnings.String())
+	}
+}
+
+func TestGet_UsesCachedRefreshToken(t *testing.T) {
+	refreshToken := makeToken(t, time.Now().Add(3*time.Hour))
+	auth := &fakeAuthenticator{accessToken: "azure-token", tenantID: "tenant", refreshToken: refreshToken}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.cache = NewMemoryCacheStore()
+
+	if _, secret, err := helper.Get("myregistry.azurecr.io"); err != nil || secret != refreshToken {
+		t.Fatalf("expected refresh token, got %q, %v", secret, err)
+	}
+
+	auth.accessTokenErr = errors.New("must not be called")
+	if _, secret, err := helper.Get("https://myregistry.azurecr.io"); err != nil || secret != refreshToken {
+		t.Errorf("expected cached refresh token, got %q, %v", secret, err)
+	}
+
+	// Tokens close to expiry are not reused
+	helper.cache = NewMemoryCacheStore()
+	auth.accessTokenErr = nil
+	auth.refreshToken = makeToken(t, time.Now().Add(time.Minute))
+	helper.Get("myregistry.azurecr.io")
+	auth.accessTokenErr = errors.New("acquired again")
+	if _, _, err := helper.Get("myregistry.azurecr.io"); err == nil {
+		t.Error("expected short-lived token not to be cached")
+	}
+}
+
+// writeAzureProfile saves an Azure CLI profile whose default subscription
+// belongs to user, with the byte order mark the CLI writes
+func writeAzureProfile(t *testing.T, dir, user string) {
+	t.Helper()
+	profile := `{"subscriptions": [` +
+		`{"id": "other", "isDefault": false, "tenantId": "t0", "user": {"name": "other@example.com", "type": "user"}},` +
+		`{"id": "sub", "isDefault": true, "tenantId": "t1", "user": {"name": "` + user + `", "type": "user"}}]}`
+	if err := os.WriteFile(filepath.Join(dir, "azureProfile.json"), []byte("\xef\xbb\xbf"+profile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenCacheKey_CredentialIdentity(t *testing.T) {
+	recordPath := filepath.Join(t.TempDir(), "authentication-record.json")
+	azureConfigDir := t.TempDir()
+	t.Setenv(EnvAuthRecord, recordPath)
+	t.Setenv("AZURE_CONFIG_DIR", azureConfigDir)
+	t.Setenv("AZURE_CLIENT_ID", "")
+	t.Setenv("AZURE_TENANT_ID", "")
+
+	const host = "myregistry.azurecr.io"
+	helper := NewACRHelperWithAuthenticator(NewAzureAuthenticator())
+	keys := map[string]string{}
+	addKey := func(name string) {
+		t.Helper()
+		key := helper.refreshTokenCacheKey(host)
+		for other, otherKey := range keys {
+			if key == otherKey {
+				t.Fatalf("%s and %s share the cache key %s", name, other, key)
+			}
+		}
+		keys[name] = key
+	}
+
+	addKey("no account")
+
+	writeAzureProfile(t, azureConfigDir, "alice@example.com")
+	addKey("az login alice")
+	writeAzureProfile(t, azureConfigDir, "bob@example.com")
+	addKey("az login bob")
+
+	t.Setenv("AZURE_CLIENT_ID", "00000000-0000-0000-0000-000000000001")
+	addKey("service principal")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	if err := SaveAuthenticationRecord(recordPath, azidentity.AuthenticationRecord{HomeAccountID: "carol.home", Username: "carol@example.com", Version: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	addKey("login carol")
+	if err := SaveAuthenticationRecord(recordPath, azidentity.AuthenticationRecord{HomeAccountID: "dave.home", Username: "dave@example.com", Version: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	addKey("login dave")
+
+	helper.authenticator = &AzureAuthenticator{credentialKind: CredentialDeviceCode}
+	addKey("devicecode dave")
+
+	helper.authenticator = &PluginAuthenticator{AzureAuthenticator: NewAzureAuthenticator(), plugin: &ExecPlugin{command: "get-token"}}
+	addKey("plugin")
+
+	helper.broker = &brokerClient{url: "http://127.0.0.1:8484"}
+	addKey("broker")
+
+	// Logging out returns to the Azure CLI account
+	helper.broker = nil
+	helper.authenticator = NewAzureAuthenticator()
+	if _, err := RemoveAuthenticationRecord(recordPath); err != nil {
+		t.Fatal(err)
+	}
+	if key := helper.refreshTokenCacheKey(host); key != keys["az login bob"] {
+		t.Errorf("expected the Azure CLI account's key after logout, got %s", key)
+	}
+
+	for _, key := range keys {
+		if strings.Contains(key, "example.com") {
+			t.Errorf("cache key %s reveals the account", key)
+		}
+	}
+}
+
+func TestGetValidFor_SkipsShortLivedCachedToken(t *testing.T) {
+	cached := makeToken(t, time.Now().Add(10*time.Minute))
+	auth := &fakeAuthenticator{accessToken: "azure-token", tenantID: "tenant", refreshToken: cached}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.cache = NewMemoryCacheStore()
+	helper.cacheRefreshToken("myregistry.azurecr.io", ACRRefreshTokenUsername, cached, time.Time{})
+
+	auth.refreshToken = makeToken(t, time.Now().Add(3*time.Hour))
+	if _, secret, err := helper.Get("myregistry.azurecr.io"); err != nil || secret != cached {
+		t.Errorf("expected the cached token, got %q, %v", secret, err)
+	}
+	if _, secret, err := helper.GetValidFor("myregistry.azurecr.io", 16*time.Minute); err != nil || secret != auth.refreshToken {
+		t.Errorf("expected a new token, got %q, %v", secret, err)
+	}
+}
@@ -0,0 +1,283 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Registry API base path probed for the authentication challenge
+	RegistryProbePath = "/v2/"
+
+	// Timeout for the challenge probe (kept short, the probe is best effort)
+	ChallengeProbeTimeout = 5 * time.Second
+
+	// Discovered challenges are kept in the token cache for this long, so
+	// one-shot invocations do not probe every time
+	challengeCacheTTL = 24 * time.Hour
+)
+
+// AuthChallenge holds the parameters of a Bearer WWW-Authenticate challenge
+type AuthChallenge struct {
+	Realm   string
+	Service string
+
+	// Params holds all challenge parameters, including ones ACR does not
+	// document (e.g. authorization_uri)
+	Params map[string]string
+}
+
+// ExchangeURL returns the token exchange endpoint that belongs to the realm.
+// ACR advertises its /oauth2/token endpoint as realm; the exchange endpoint
+// lives next to it.
+func (c *AuthChallenge) ExchangeURL() (string, error) {
+	realm, err := url.Parse(c.Realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid realm %q: %w", c.Realm, err)
+	}
+
+	if strings.HasSuffix(realm.Path, ACRTokenPath) {
+		realm.Path = strings.TrimSuffix(realm.Path, ACRTokenPath) + ACRTokenExchangePath
+	} else {
+		realm.Path = ACRTokenExchangePath
+	}
+	realm.RawQuery = ""
+	realm.Fragment = ""
+
+	return realm.String(), nil
+}
+
+// ParseBearerChallenge parses a WWW-Authenticate header value of the form
+// `Bearer realm="...",service="..."`
+func ParseBearerChallenge(header string) (*AuthChallenge, error) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+
+	values := parseChallengeParams(params)
+	challenge := &AuthChallenge{
+		Realm:   values["realm"],
+		Service: values["service"],
+		Params:  values,
+	}
+
+	if challenge.Realm == "" {
+		return nil, fmt.Errorf("challenge does not contain a realm")
+	}
+
+	return challenge, nil
+}
+
+// parseChallengeParams splits comma separated key=value pairs, honouring
+// quoted values that may themselves contain commas
+func parseChallengeParams(params string) map[string]string {
+	values := map[string]string{}
+
+	for params != "" {
+		params = strings.TrimLeft(params, " ,")
+		key, rest, found := strings.Cut(params, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimLeft(rest, " ")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			var b strings.Builder
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' && end+1 < len(rest) {
+					end++
+				}
+				b.WriteByte(rest[end])
+				end++
+			}
+			value = b.String()
+			params = rest[min(end+1, len(rest)):]
+		} else {
+			value, params, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+		}
+
+		values[key] = value
+	}
+
+	return values
+}
+
+// challengeCache remembers discovered challenges per registry host
+type challengeCache struct {
+	mu      sync.Mutex
+	entries map[string]*AuthChallenge
+}
+
+func (c *challengeCache) get(host string) (*AuthChallenge, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	challenge, ok := c.entries[host]
+	return challenge, ok
+}
+
+func (c *challengeCache) put(host string, challenge *AuthChallenge) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*AuthChallenge{}
+	}
+	c.entries[host] = challenge
+}
+
+// cachedChallenge returns the challenge discovered earlier by this or, with
+// a token cache, another invocation. Realms are validated again, as the
+// trusted realm hosts may have changed since.
+func (a *AzureAuthenticator) cachedChallenge(registryHost string) (*AuthChallenge, bool) {
+	if challenge, ok := a.challenges.get(registryHost); ok {
+		return challenge, true
+	}
+	if a.cache == nil {
+		return nil, false
+	}
+
+	data, err := a.cache.Get("challenge/" + registryHost)
+	if err != nil {
+		return nil, false
+	}
+	var challenge AuthChallenge
+	if json.Unmarshal(data, &challenge) != nil || a.validateRealm(registryHost, challenge.Realm) != nil {
+		return nil, false
+	}
+
+	a.challenges.put(registryHost, &challenge)
+	return &challenge, true
+}
+
+// DiscoverChallenge probes GET /v2/ on the registry and returns the advertised
+// Bearer challenge. Successful results are cached per host, in the token
+// cache when there is one.
+func (a *AzureAuthenticator) DiscoverChallenge(registryHost string) (*AuthChallenge, error) {
+	if challenge, ok := a.cachedChallenge(registryHost); ok {
+		return challenge, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ChallengeProbeTimeout)
+	defer cancel()
+
+	probeURL := fmt.Sprintf("https://%s%s", registryHost, RegistryProbePath)
+	req, err := http.NewRequestWithContext(ctx, "GET", probeURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create challenge probe request: %w", err)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("challenge probe failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, fmt.Errorf("challenge probe returned status %d, expected 401", resp.StatusCode)
+	}
+
+	var challenge *AuthChallenge
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		if challenge, err = ParseBearerChallenge(header); err == nil {
+			break
+		}
+	}
+	if challenge == nil {
+		return nil, fmt.Errorf("no Bearer challenge in probe response")
+	}
+
+	if err := a.validateRealm(registryHost, challenge.Realm); err != nil {
+		return nil, err
+	}
+
+	a.challenges.put(registryHost, challenge)
+	if a.cache != nil {
+		if data, err := json.Marshal(challenge); err == nil {
+			_ = a.cache.Set("challenge/"+registryHost, data, challengeCacheTTL)
+		}
+	}
+	return challenge, nil
+}
+
+// validateRealm ensures the Azure access token is only ever sent over HTTPS
+// to the registry itself, another ACR host or a trusted realm host
+func (a *AzureAuthenticator) validateRealm(registryHost, realm string) error {
+	parsed, err := url.Parse(realm)
+	if err != nil {
+		return fmt.Errorf("invalid realm %q: %w", realm, err)
+	}
+
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("realm %q does not use https", realm)
+	}
+
+	host := strings.ToLower(parsed.Host)
+	if host == strings.ToLower(registryHost) || strings.HasSuffix(parsed.Hostname(), ACRDomainSuffix) {
+		return nil
+	}
+	for _, trusted := range a.trustedRealmHosts {
+		if matchRealmHost(trusted, host) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("realm %q is not served by the registry, an ACR host or a trusted realm host (%s)", realm, EnvTrustedRealmHosts)
+}
+
+// matchRealmHost reports whether host (with port, if any) matches a trusted
+// realm host: an exact host, or "*.example.com" for its subdomains
+func matchRealmHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+// resolveExchangeEndpoint returns the exchange URL and service name for a
+// registry, preferring the discovered challenge and falling back to the
+// conventional ACR endpoint when discovery fails
+func (a *AzureAuthenticator) resolveExchangeEndpoint(registryHost string) (string, string) {
+	exchangeURL := fmt.Sprintf("https://%s%s", registryHost, ACRTokenExchangePath)
+	service := registryHost
+
+	challenge, err := a.DiscoverChallenge(registryHost)
+	if err != nil {
+		return exchangeURL, service
+	}
+
+	if discoveredURL, err := challenge.ExchangeURL(); err == nil {
+		exchangeURL = discoveredURL
+	}
+	if challenge.Service != "" {
+		service = challenge.Service
+	}
+
+	return exchangeURL, service
+}
+
+// resolveTokenEndpoint returns the realm (token endpoint) and service name for
+// a registry, falling back to the conventional ACR endpoint when discovery fails
+func (a *AzureAuthenticator) resolveTokenEndpoint(registryHost string) (string, string) {
+	challenge, err := a.DiscoverChallenge(registryHost)
+	if err != nil {
+		return fmt.Sprintf("https://%s%s", registryHost, ACRTokenPath), registryHost
+	}
+
+	service := challenge.Service
+	if service == "" {
+		service = registryHost
+	}
+
+	return challenge.Realm, service
+}
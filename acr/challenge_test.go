@@ -0,0 +1,236 @@
+package acr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseBearerChallenge(t *testing.T) {
+	challenge, err := ParseBearerChallenge(
+		`Bearer realm="https://myregistry.azurecr.io/oauth2/token",service="myregistry.azurecr.io"`,
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if challenge.Realm != "https://myregistry.azurecr.io/oauth2/token" {
+		t.Errorf("unexpected realm: %s", challenge.Realm)
+	}
+	if challenge.Service != "myregistry.azurecr.io" {
+		t.Errorf("unexpected service: %s", challenge.Service)
+	}
+}
+
+func TestParseBearerChallenge_QuotedCommaAndUnquoted(t *testing.T) {
+	challenge, err := ParseBearerChallenge(`bearer service=svc, realm="https://a.azurecr.io/x,y", scope="a,b"`)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if challenge.Realm != "https://a.azurecr.io/x,y" || challenge.Service != "svc" {
+		t.Errorf("unexpected challenge: %+v", challenge)
+	}
+}
+
+func TestParseBearerChallenge_Invalid(t *testing.T) {
+	for _, header := range []string{"", `Basic realm="x"`, `Bearer service="svc"`} {
+		if _, err := ParseBearerChallenge(header); err == nil {
+			t.Errorf("expected error for %q, got nil", header)
+		}
+	}
+}
+
+func TestAuthChallenge_ExchangeURL(t *testing.T) {
+	tests := map[string]string{
+		"https://myregistry.azurecr.io/oauth2/token":       "https://myregistry.azurecr.io/oauth2/exchange",
+		"https://proxy.example.com/acr/oauth2/token?x=1":   "https://proxy.example.com/acr/oauth2/exchange",
+		"https://myregistry.westeurope.data.azurecr.io/do": "https://myregistry.westeurope.data.azurecr.io/oauth2/exchange",
+	}
+	for realm, want := range tests {
+		got, err := (&AuthChallenge{Realm: realm}).ExchangeURL()
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", realm, err)
+		}
+		if got != want {
+			t.Errorf("ExchangeURL(%s) = %s, want %s", realm, got, want)
+		}
+	}
+}
+
+func TestExchangeForACRToken_UsesDiscoveredChallenge(t *testing.T) {
+	var probes atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			probes.Add(1)
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="`+server.URL+`/proxied/oauth2/token",service="real.azurecr.io"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/proxied/oauth2/exchange":
+			if err := r.ParseForm(); err != nil {
+				t.Errorf("failed to parse form: %v", err)
+			}
+			if got := r.PostForm.Get("service"); got != "real.azurecr.io" {
+				t.Errorf("expected discovered service, got: %s", got)
+			}
+			w.Write([]byte(`{"refresh_token":"discovered-token"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	for i := 0; i < 2; i++ {
+		token, err := auth.ExchangeForACRToken(host, "tenant", "azure-token")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if token != "discovered-token" {
+			t.Errorf("expected discovered-token, got: %s", token)
+		}
+	}
+
+	if probes.Load() != 1 {
+		t.Errorf("expected challenge to be cached after one probe, got %d probes", probes.Load())
+	}
+}
+
+func TestExchangeForACRToken_FallsBackWhenProbeFails(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusInternalServerError)
+		case ACRTokenExchangePath:
+			if got := r.FormValue("service"); got != r.Host {
+				t.Errorf("expected service to be the registry host, got: %s", got)
+			}
+			w.Write([]byte(`{"refresh_token":"fallback-token"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+
+	token, err := auth.ExchangeForACRToken(strings.TrimPrefix(server.URL, "https://"), "tenant", "azure-token")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if token != "fallback-token" {
+		t.Errorf("expected fallback-token, got: %s", token)
+	}
+}
+
+func TestDiscoverChallenge_RejectsForeignRealm(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="https://evil.example.com/oauth2/token",service="x"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+
+	_, err := auth.DiscoverChallenge(strings.TrimPrefix(server.URL, "https://"))
+	if err == nil {
+		t.Fatal("expected error for foreign realm, got nil")
+	}
+	if !strings.Contains(err.Error(), "not served by the registry") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestExchangeForACRToken_TrustedRealmHost(t *testing.T) {
+	var probes atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			probes.Add(1)
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="https://proxy.example.com/acr/oauth2/token",service="myregistry.azurecr.io"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/acr/oauth2/exchange":
+			w.Write([]byte(`{"refresh_token":"proxy-token"}`))
+		case ACRTokenExchangePath:
+			w.Write([]byte(`{"refresh_token":"registry-token"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	cache := NewMemoryCacheStore()
+	newAuth := func(trusted []string) (*AzureAuthenticator, *hostRewriter) {
+		auth, err := NewAzureAuthenticatorFromConfig(&Config{TrustedRealmHosts: trusted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewriter := &hostRewriter{target: target, base: server.Client().Transport}
+		auth.httpClient = &http.Client{Transport: rewriter}
+		auth.cache = cache
+		return auth, rewriter
+	}
+
+	// Untrusted proxy realm: the exchange stays on the registry
+	auth, rewriter := newAuth(nil)
+	token, err := auth.ExchangeForACRToken("myregistry.azurecr.io", "tenant", "azure-token")
+	if err != nil || token != "registry-token" {
+		t.Fatalf("expected the registry's exchange endpoint, got %q, %v", token, err)
+	}
+	if got := rewriter.hosts[len(rewriter.hosts)-1]; got != "myregistry.azurecr.io" {
+		t.Errorf("expected the exchange on the registry, got %s", got)
+	}
+
+	auth, rewriter = newAuth([]string{"*.example.com"})
+	token, err = auth.ExchangeForACRToken("myregistry.azurecr.io", "tenant", "azure-token")
+	if err != nil || token != "proxy-token" {
+		t.Fatalf("expected the proxy's exchange endpoint, got %q, %v", token, err)
+	}
+	if got := rewriter.hosts[len(rewriter.hosts)-1]; got != "proxy.example.com" {
+		t.Errorf("expected the exchange on the proxy, got %s", got)
+	}
+
+	// Another invocation sharing the token cache reuses the challenge
+	auth, rewriter = newAuth([]string{"proxy.example.com"})
+	if token, err := auth.ExchangeForACRToken("myregistry.azurecr.io", "tenant", "azure-token"); err != nil || token != "proxy-token" {
+		t.Fatalf("expected the proxy's exchange endpoint, got %q, %v", token, err)
+	}
+	if len(rewriter.hosts) != 1 {
+		t.Errorf("expected the exchange alone, got requests to %v", rewriter.hosts)
+	}
+	if got := probes.Load(); got != 2 {
+		t.Errorf("expected the cached challenge to be reused, got %d probes", got)
+	}
+}
+
+func TestMatchRealmHost(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"proxy.example.com", "proxy.example.com", true},
+		{"Proxy.Example.com", "proxy.example.com", true},
+		{"proxy.example.com", "proxy.example.com:8443", false},
+		{"proxy.example.com:8443", "proxy.example.com:8443", true},
+		{"*.example.com", "proxy.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "evilexample.com", false},
+		{" proxy.example.com", "proxy.example.com", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchRealmHost(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("matchRealmHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
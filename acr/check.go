@@ -0,0 +1,190 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Registry actions that can be checked
+const (
+	ActionPull = "pull"
+	ActionPush = "push"
+)
+
+const (
+	// Repository checked when none is given. Registry-wide role assignments
+	// such as AcrPull apply to every repository name.
+	PermissionProbeRepository = "permission-probe"
+
+	// Actions requested when checking a repository, so the response shows
+	// everything the identity is granted
+	checkedActions = "pull,push,delete"
+)
+
+// AccessTokenProvider is implemented by authenticators that can request
+// repository-scoped ACR access tokens
+type AccessTokenProvider interface {
+	// GetACRAccessToken trades registry credentials for an access token
+	// limited to scope (e.g. "repository:team/app:pull")
+	GetACRAccessToken(registryHost, username, secret, scope string) (string, error)
+}
+
+// GrantedAccess is an entry of the 'access' claim of an ACR access token
+type GrantedAccess struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// PermissionCheck is the result of a permission check
+type PermissionCheck struct {
+	Registry   string   `json:"registry"`
+	Repository string   `json:"repository"`
+	Action     string   `json:"action"`
+	Granted    []string `json:"granted"`
+}
+
+// Allowed reports whether the checked action was granted
+func (c *PermissionCheck) Allowed() bool {
+	return slices.Contains(c.Granted, c.Action) || slices.Contains(c.Granted, "*")
+}
+
+// CheckPermission obtains credentials for the registry like Get and requests
+// an access token for the repository, returning the actions it grants
+func (h *ACRHelper) CheckPermission(serverURL, repository, action string) (*PermissionCheck, error) {
+	if action != ActionPull && action != ActionPush {
+		return nil, fmt.Errorf("unsupported action %q: must be pull or push", action)
+	}
+	if repository == "" {
+		repository = PermissionProbeRepository
+	}
+
+	provider, ok := h.authenticator.(AccessTokenProvider)
+	if !ok {
+		return nil, fmt.Errorf("authenticator cannot request ACR access tokens")
+	}
+
+	registryHost, _, err := h.validator.ParseAndNormalize(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	username, secret, err := h.Get(registryHost)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := "repository:" + repository + ":" + checkedActions
+	accessToken, err := provider.GetACRAccessToken(registryHost, username, secret, scope)
+	if err != nil {
+		if h.config.Registry(registryHost).AdminCredentials {
+			h.forgetAdminCredentials(registryHost)
+		}
+		return nil, err
+	}
+
+	access, err := ParseAccessClaim(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &PermissionCheck{
+		Registry:   registryHost,
+		Repository: repository,
+		Action:     action,
+		Granted:    []string{},
+	}
+	for _, entry := range access {
+		if entry.Type == "repository" && entry.Name == repository {
+			check.Granted = append(check.Granted, entry.Actions...)
+		}
+	}
+
+	return check, nil
+}
+
+// ParseAccessClaim returns the 'access' claim of an ACR access token. The
+// signature is not verified.
+func ParseAccessClaim(accessToken string) ([]GrantedAccess, error) {
+	var claims struct {
+		jwt.RegisteredClaims
+		Access []GrantedAccess `json:"access"`
+	}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, &claims); err != nil {
+		return nil, fmt.Errorf("failed to parse access token: %w", err)
+	}
+	return claims.Access, nil
+}
+
+// GetACRAccessToken requests an access token from the registry's token
+// endpoint. Refresh tokens use the OAuth2 refresh_token grant; other
+// credentials (scope map tokens, admin user) use basic authentication and
+// empty credentials request an anonymous token.
+func (a *AzureAuthenticator) GetACRAccessToken(registryHost, username, secret, scope string) (string, error) {
+	realm, service := a.resolveTokenEndpoint(registryHost)
+
+	ctx, cancel := context.WithTimeout(context.Background(), TokenRequestTimeout)
+	defer cancel()
+
+	var req *http.Request
+	var err error
+	if username == ACRRefreshTokenUsername {
+		form := url.Values{
+			"grant_type":    []string{"refresh_token"},
+			"service":       []string{service},
+			"scope":         []string{scope},
+			"refresh_token": []string{secret},
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", realm, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		var tokenURL *url.URL
+		if tokenURL, err = url.Parse(realm); err == nil {
+			tokenURL.RawQuery = url.Values{"service": []string{service}, "scope": []string{scope}}.Encode()
+			req, err = http.NewRequestWithContext(ctx, "GET", tokenURL.String(), nil)
+		}
+		if err == nil && secret != "" {
+			req.SetBasicAuth(username, secret)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create access token request: %w", err)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("access token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return "", fmt.Errorf("access token request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		Token       string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("failed to parse access token response: %w", err)
+	}
+
+	if tokenResp.AccessToken != "" {
+		return tokenResp.AccessToken, nil
+	}
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return "", fmt.Errorf("access token response contains no token")
+}
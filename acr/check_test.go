@@ -0,0 +1,148 @@
+package acr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func makeAccessToken(t *testing.T, access []GrantedAccess) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"access": access,
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	return token
+}
+
+// newCheckRegistry serves the challenge, exchange and token endpoints of a
+// registry that grants the given actions on every requested repository
+func newCheckRegistry(t *testing.T, granted []string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case RegistryProbePath:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/oauth2/token",service="checkregistry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case ACRTokenExchangePath:
+			w.Write([]byte(`{"refresh_token":"refresh-token"}`))
+		case ACRTokenPath:
+			var scope string
+			switch {
+			case r.Method == "POST" && r.FormValue("grant_type") == "refresh_token" && r.FormValue("refresh_token") == "refresh-token":
+				scope = r.FormValue("scope")
+			case r.Method == "GET":
+				if user, pass, ok := r.BasicAuth(); !ok || user != "partner" || pass != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				scope = r.URL.Query().Get("scope")
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.FormValue("service") != "checkregistry" {
+				t.Errorf("unexpected service %q", r.FormValue("service"))
+			}
+
+			parts := strings.Split(scope, ":")
+			access := []GrantedAccess{{Type: parts[0], Name: parts[1], Actions: granted}}
+			w.Write([]byte(`{"access_token":"` + makeAccessToken(t, access) + `"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestGetACRAccessToken_RefreshToken(t *testing.T) {
+	server := newCheckRegistry(t, []string{"pull"})
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+
+	refreshToken, err := auth.ExchangeForACRToken(host, "tenant", "azure-token")
+	if err != nil {
+		t.Fatalf("exchange failed: %v", err)
+	}
+	accessToken, err := auth.GetACRAccessToken(host, ACRRefreshTokenUsername, refreshToken, "repository:team/app:"+checkedActions)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	access, err := ParseAccessClaim(accessToken)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	want := []GrantedAccess{{Type: "repository", Name: "team/app", Actions: []string{"pull"}}}
+	if !reflect.DeepEqual(access, want) {
+		t.Errorf("expected %+v, got %+v", want, access)
+	}
+}
+
+func TestGetACRAccessToken_BasicAuth(t *testing.T) {
+	server := newCheckRegistry(t, []string{"pull", "push"})
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	if _, err := auth.GetACRAccessToken(host, "partner", "secret", "repository:app:pull"); err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := auth.GetACRAccessToken(host, "partner", "wrong", "repository:app:pull"); err == nil ||
+		!strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected 401 error, got: %v", err)
+	}
+}
+
+// accessTokenAuthenticator grants fixed actions on every repository
+type accessTokenAuthenticator struct {
+	fakeAuthenticator
+	t       *testing.T
+	granted []string
+	scope   string
+}
+
+func (a *accessTokenAuthenticator) GetACRAccessToken(_, username, secret, scope string) (string, error) {
+	if username != ACRRefreshTokenUsername || secret != a.refreshToken {
+		a.t.Errorf("unexpected credentials %s/%s", username, secret)
+	}
+	a.scope = scope
+	name := strings.Split(scope, ":")[1]
+	return makeAccessToken(a.t, []GrantedAccess{{Type: "repository", Name: name, Actions: a.granted}}), nil
+}
+
+func TestACRHelper_CheckPermission(t *testing.T) {
+	auth := &accessTokenAuthenticator{fakeAuthenticator: *successAuthenticator(), t: t, granted: []string{"pull"}}
+	helper := NewACRHelperWithAuthenticator(auth)
+
+	check, err := helper.CheckPermission("https://myregistry.azurecr.io", "team/app", ActionPull)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !check.Allowed() || check.Registry != "myregistry.azurecr.io" || auth.scope != "repository:team/app:pull,push,delete" {
+		t.Errorf("unexpected check %+v for scope %s", check, auth.scope)
+	}
+
+	check, err = helper.CheckPermission("myregistry.azurecr.io", "", ActionPush)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if check.Allowed() || check.Repository != PermissionProbeRepository {
+		t.Errorf("expected push to be missing on the probe repository, got %+v", check)
+	}
+
+	if _, err := helper.CheckPermission("myregistry.azurecr.io", "", "delete"); err == nil {
+		t.Error("expected error for unsupported action")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package acr
+
+import (
+	"context"
+	"os/exec"
+)
+
+// CommandFor runs a user-configured command line with the shell: sh -c
+func CommandFor(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command) // #nosec G204 -- command is configured by the user
+}
@@ -0,0 +1,17 @@
+package acr
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCommandFor(t *testing.T) {
+	out, err := CommandFor(context.Background(), "echo hello && echo world").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(out)); len(got) != 2 || got[0] != "hello" || got[1] != "world" {
+		t.Errorf("expected both commands to run, got %q", out)
+	}
+}
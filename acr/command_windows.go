@@ -0,0 +1,18 @@
+//go:build windows
+
+package acr
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// CommandFor runs a user-configured command line with cmd /C. cmd.exe does
+// not parse its arguments like other programs, so the command line is
+// passed through verbatim instead of being quoted by os/exec.
+func CommandFor(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd.exe") // #nosec G204 -- command is configured by the user
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `cmd.exe /S /C "` + command + `"`}
+	return cmd
+}
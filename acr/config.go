@@ -0,0 +1,447 @@
+package acr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Environment variables controlling optional helper behaviour. They take
+// precedence over the configuration file.
+const (
+	// Location of the configuration file
+	EnvConfigFile = "DOCKER_CREDENTIAL_ACR_CONFIG"
+
+	// Comma separated registries that get empty credentials if they allow
+	// anonymous pulls
+	EnvAnonymousPull = "DOCKER_CREDENTIAL_ACR_ANONYMOUS_PULL"
+
+	// Request tokens for the tenant discovered from the registry
+	EnvTenantDiscovery = "DOCKER_CREDENTIAL_ACR_TENANT_DISCOVERY"
+
+	// Accept image references and /v2/ URLs in place of registry hosts
+	EnvLenientRegistryParsing = "DOCKER_CREDENTIAL_ACR_LENIENT_REGISTRY_PARSING"
+
+	// Comma separated hosts besides ACR that may serve a registry's token
+	// endpoint ("*.example.com" matches subdomains)
+	EnvTrustedRealmHosts = "DOCKER_CREDENTIAL_ACR_TRUSTED_REALM_HOSTS"
+
+	// Proxy URL for Azure AD and ACR requests (overrides HTTPS_PROXY)
+	EnvProxy = "DOCKER_CREDENTIAL_ACR_PROXY"
+
+	// Hosts bypassing the proxy (NO_PROXY syntax, overrides NO_PROXY)
+	EnvNoProxy = "DOCKER_CREDENTIAL_ACR_NO_PROXY"
+
+	// Extra trusted CA PEM files, separated by the OS path list separator
+	EnvCAFiles = "DOCKER_CREDENTIAL_ACR_CA_FILES"
+
+	// Client certificate and key PEM files for mutual TLS
+	EnvClientCert = "DOCKER_CREDENTIAL_ACR_CLIENT_CERT"
+	EnvClientKey  = "DOCKER_CREDENTIAL_ACR_CLIENT_KEY"
+
+	// Minimum TLS version ("1.2" or "1.3")
+	EnvMinTLSVersion = "DOCKER_CREDENTIAL_ACR_MIN_TLS_VERSION"
+
+	// Azure Resource Manager endpoint (e.g. for sovereign clouds)
+	EnvARMEndpoint = "DOCKER_CREDENTIAL_ACR_ARM_ENDPOINT"
+
+	// Refresh token cache backend (none, memory, file, keyring), the
+	// encrypted file cache directory and its key (machine, passphrase)
+	EnvCacheBackend = "DOCKER_CREDENTIAL_ACR_CACHE"
+	EnvCacheDir     = "DOCKER_CREDENTIAL_ACR_CACHE_DIR"
+	EnvCacheKey     = "DOCKER_CREDENTIAL_ACR_CACHE_KEY"
+
+	// How long classified failures are cached ("0" disables the failure cache)
+	EnvFailureCacheTTL = "DOCKER_CREDENTIAL_ACR_FAILURE_CACHE_TTL"
+
+	// External command issuing tokens (see PluginConfig)
+	EnvPlugin = "DOCKER_CREDENTIAL_ACR_PLUGIN"
+
+	// URL of a serve-http credential broker to fetch credentials from
+	EnvBrokerURL = "DOCKER_CREDENTIAL_ACR_BROKER_URL"
+
+	// Secret shared between a credential broker and its clients
+	EnvBrokerSecret = "DOCKER_CREDENTIAL_ACR_BROKER_SECRET" // #nosec G101
+
+	// Credential to authenticate with (default, devicecode, browser)
+	EnvCredential = "DOCKER_CREDENTIAL_ACR_CREDENTIAL"
+
+	// Keep workload identity federation tokens in the persistent MSAL token cache
+	EnvPersistentCache = "DOCKER_CREDENTIAL_ACR_PERSISTENT_CACHE"
+
+	// Location of the authentication record of interactive sign-ins
+	EnvAuthRecord = "DOCKER_CREDENTIAL_ACR_AUTH_RECORD"
+
+	// Workload identity federation: assertion source (file, env, command,
+	// github) and its file path, variable name or command
+	EnvAssertionSource = "DOCKER_CREDENTIAL_ACR_ASSERTION_SOURCE"
+	EnvAssertion       = "DOCKER_CREDENTIAL_ACR_ASSERTION"
+
+	// Comma separated tenants the credential may acquire tokens for,
+	// read by the Azure SDK as well
+	EnvAdditionalTenants = "AZURE_ADDITIONALLY_ALLOWED_TENANTS"
+)
+
+// Config holds optional helper settings. The zero value keeps the default
+// behaviour of always authenticating via Azure.
+type Config struct {
+	// TenantDiscovery requests the Azure token for the tenant discovered
+	// from the registry when no tenant is configured for it and it is not
+	// the home tenant. The discovered tenant must be in AdditionalTenants.
+	TenantDiscovery bool `json:"tenantDiscovery,omitempty"`
+
+	// LenientRegistryParsing extracts the registry from image references
+	// (myregistry.azurecr.io/team/app:1.2) and /v2/ URLs passed by some tools
+	// instead of rejecting them; ports and user info are still rejected
+	LenientRegistryParsing bool `json:"lenientRegistryParsing,omitempty"`
+
+	// TrustedRealmHosts may serve the token endpoint (realm) advertised by a
+	// registry, e.g. a registry proxy; Azure AD tokens are only sent to the
+	// registry itself and ACR hosts otherwise
+	TrustedRealmHosts []string `json:"trustedRealmHosts,omitempty"`
+
+	// Credential selects how to authenticate: default (DefaultAzureCredential),
+	// devicecode or browser (interactive sign-in)
+	Credential string `json:"credential,omitempty"`
+
+	// PersistentCache stores the Azure AD tokens of workload identity
+	// federation in the helper's persistent MSAL token cache. Interactive
+	// sign-ins always use it; DefaultAzureCredential does not support it.
+	PersistentCache bool `json:"persistentCache,omitempty"`
+
+	// Transport configures proxy and TLS settings for Azure AD and ACR
+	Transport TransportConfig `json:"transport,omitempty"`
+
+	// Assertion enables workload identity federation from an OIDC token
+	Assertion AssertionConfig `json:"assertion,omitempty"`
+
+	// AdditionalTenants lists tenants besides the home tenant the credential
+	// may acquire tokens for ("*" allows any)
+	AdditionalTenants []string `json:"additionalTenants,omitempty"`
+
+	// Plugin obtains tokens from an external command instead of the Azure
+	// Identity SDK
+	Plugin PluginConfig `json:"plugin,omitempty"`
+
+	// Broker fetches credentials from a serve-http broker instead of
+	// authenticating to Azure
+	Broker BrokerConfig `json:"broker,omitempty"`
+
+	// Cache selects where refresh tokens are cached between invocations
+	Cache CacheConfig `json:"cache,omitempty"`
+
+	// FailureCacheTTL is how long authentication failures that will not go
+	// away by retrying (e.g. missing permissions) are returned from the cache,
+	// as a Go duration; "0" disables it (default: 30s)
+	FailureCacheTTL string `json:"failureCacheTTL,omitempty"`
+
+	// ARMEndpoint overrides the Azure Resource Manager endpoint
+	ARMEndpoint string `json:"armEndpoint,omitempty"`
+
+	// Registries holds per-registry settings keyed by normalized host
+	Registries map[string]RegistryConfig `json:"registries,omitempty"`
+}
+
+// RegistryConfig holds settings for a single registry
+type RegistryConfig struct {
+	// Tenant owning the registry; the Azure token is requested for this
+	// tenant instead of the identity's home tenant
+	Tenant string `json:"tenant,omitempty"`
+
+	// Token returns the credentials of an ACR repository-scoped token
+	// instead of exchanging an Azure AD token
+	Token *ScopeMapTokenConfig `json:"token,omitempty"`
+
+	// AdminCredentials returns the registry's admin user credentials, read
+	// via the ARM listCredentials action, instead of a refresh token
+	AdminCredentials bool `json:"adminCredentials,omitempty"`
+
+	// ResourceID of the registry for AdminCredentials; looked up via Azure
+	// Resource Graph when empty
+	ResourceID string `json:"resourceId,omitempty"`
+
+	// AnonymousPull returns empty credentials instead of authenticating via
+	// Azure if the registry issues anonymous pull tokens. Docker does not
+	// tell the helper whether it pulls or pushes, so pushes to the registry
+	// fail; only set it for registries that are never pushed to.
+	AnonymousPull bool `json:"anonymousPull,omitempty"`
+}
+
+// DefaultConfigFile returns $DOCKER_CREDENTIAL_ACR_CONFIG, falling back to
+// <user config dir>/docker-credential-acr/config.json
+func DefaultConfigFile() (string, error) {
+	if path := os.Getenv(EnvConfigFile); path != "" {
+		return path, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine config file location: %w", err)
+	}
+	return filepath.Join(configDir, "docker-credential-acr", "config.json"), nil
+}
+
+// LoadConfig reads the configuration file (if present) and applies
+// environment variable overrides
+func LoadConfig() (*Config, error) {
+	path, err := DefaultConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	// Registry keys are parsed once the parsing mode is known
+	if err := cfg.normalizeRegistries(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := cfg.applyRegistryEnv(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadConfigFile reads a configuration file; a missing file yields the zero
+// configuration
+func LoadConfigFile(path string) (*Config, error) {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.normalizeRegistries(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// readConfigFile decodes a configuration file without normalizing it
+func readConfigFile(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// RegistryValidator returns the validator for the configured registry
+// parsing mode
+func (c *Config) RegistryValidator() *RegistryValidator {
+	if c.LenientRegistryParsing {
+		return NewLenientRegistryValidator()
+	}
+	return NewRegistryValidator()
+}
+
+// normalizeRegistries validates registry keys and rewrites them to their
+// normalized host form
+func (c *Config) normalizeRegistries() error {
+	if len(c.Registries) == 0 {
+		return nil
+	}
+
+	validator := c.RegistryValidator()
+	normalized := make(map[string]RegistryConfig, len(c.Registries))
+	for key, registry := range c.Registries {
+		host, _, err := validator.ParseAndNormalize(key)
+		if err != nil {
+			return fmt.Errorf("registry %q: %w", key, err)
+		}
+		if _, dup := normalized[host]; dup {
+			return fmt.Errorf("registry %s is configured more than once", host)
+		}
+		if err := registry.validate(); err != nil {
+			return fmt.Errorf("registry %s: %w", host, err)
+		}
+		normalized[host] = registry
+	}
+	c.Registries = normalized
+
+	return nil
+}
+
+// validate checks that the credential settings of a registry are consistent
+func (r RegistryConfig) validate() error {
+	if r.Token != nil {
+		if r.AdminCredentials {
+			return fmt.Errorf("token and adminCredentials cannot be combined")
+		}
+		if err := r.Token.validate(); err != nil {
+			return err
+		}
+	}
+
+	if r.ResourceID != "" && !registryResourceIDRegex.MatchString(r.ResourceID) {
+		return fmt.Errorf("invalid resourceId %q: expected "+
+			"/subscriptions/<id>/resourceGroups/<group>/providers/Microsoft.ContainerRegistry/registries/<name>", r.ResourceID)
+	}
+
+	return nil
+}
+
+// Registry returns the settings for a normalized registry host
+func (c *Config) Registry(registryHost string) RegistryConfig {
+	return c.Registries[registryHost]
+}
+
+// AllowedTenants returns the additional tenants plus every tenant configured
+// for a registry, so the credential may acquire tokens for all of them
+func (c *Config) AllowedTenants() []string {
+	seen := map[string]bool{}
+	var tenants []string
+
+	add := func(tenant string) {
+		tenant = strings.TrimSpace(tenant)
+		if tenant != "" && !seen[tenant] {
+			seen[tenant] = true
+			tenants = append(tenants, tenant)
+		}
+	}
+
+	for _, tenant := range c.AdditionalTenants {
+		add(tenant)
+	}
+	for _, registry := range c.Registries {
+		add(registry.Tenant)
+	}
+
+	return tenants
+}
+
+// TenantAllowed reports whether tokens may be requested for tenant, i.e.
+// whether it is allowed explicitly, through a registry or by "*"
+func (c *Config) TenantAllowed(tenant string) bool {
+	for _, allowed := range c.AllowedTenants() {
+		if allowed == "*" || strings.EqualFold(allowed, tenant) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRegistryEnv merges per-registry environment variables into the
+// normalized registry configuration
+func (c *Config) applyRegistryEnv() error {
+	registries := os.Getenv(EnvAnonymousPull)
+	if registries == "" {
+		return nil
+	}
+
+	validator := c.RegistryValidator()
+	for _, registry := range strings.Split(registries, ",") {
+		host, _, err := validator.ParseAndNormalize(strings.TrimSpace(registry))
+		if err != nil {
+			return fmt.Errorf("invalid %s: registry %q: %w", EnvAnonymousPull, registry, err)
+		}
+		if c.Registries == nil {
+			c.Registries = map[string]RegistryConfig{}
+		}
+		entry := c.Registries[host]
+		entry.AnonymousPull = true
+		c.Registries[host] = entry
+	}
+
+	return nil
+}
+
+// applyEnv overrides configuration values with environment variables that are set
+func (c *Config) applyEnv() error {
+	if tenantDiscovery, ok, err := envBool(EnvTenantDiscovery); err != nil {
+		return err
+	} else if ok {
+		c.TenantDiscovery = tenantDiscovery
+	}
+
+	if lenient, ok, err := envBool(EnvLenientRegistryParsing); err != nil {
+		return err
+	} else if ok {
+		c.LenientRegistryParsing = lenient
+	}
+
+	if persistentCache, ok, err := envBool(EnvPersistentCache); err != nil {
+		return err
+	} else if ok {
+		c.PersistentCache = persistentCache
+	}
+
+	envString(&c.Credential, EnvCredential)
+	envString(&c.Plugin.Command, EnvPlugin)
+	envString(&c.Broker.URL, EnvBrokerURL)
+
+	envString(&c.Transport.ProxyURL, EnvProxy)
+	envString(&c.Transport.NoProxy, EnvNoProxy)
+	if caFiles := os.Getenv(EnvCAFiles); caFiles != "" {
+		c.Transport.CAFiles = filepath.SplitList(caFiles)
+	}
+	envString(&c.Transport.ClientCertFile, EnvClientCert)
+	envString(&c.Transport.ClientKeyFile, EnvClientKey)
+	envString(&c.Transport.MinTLSVersion, EnvMinTLSVersion)
+
+	envString(&c.Cache.Backend, EnvCacheBackend)
+	envString(&c.Cache.Dir, EnvCacheDir)
+	envString(&c.Cache.Key, EnvCacheKey)
+
+	envString(&c.FailureCacheTTL, EnvFailureCacheTTL)
+	envString(&c.ARMEndpoint, EnvARMEndpoint)
+
+	envString(&c.Assertion.Source, EnvAssertionSource)
+	envString(&c.Assertion.Value, EnvAssertion)
+
+	if tenants := os.Getenv(EnvAdditionalTenants); tenants != "" {
+		c.AdditionalTenants = append(c.AdditionalTenants, strings.Split(tenants, ",")...)
+	}
+	if hosts := os.Getenv(EnvTrustedRealmHosts); hosts != "" {
+		c.TrustedRealmHosts = append(c.TrustedRealmHosts, strings.Split(hosts, ",")...)
+	}
+
+	return nil
+}
+
+// envString overrides *dst with the environment variable if it is set
+func envString(dst *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = value
+	}
+}
+
+// envBool parses a boolean environment variable; ok is false when unset
+func envBool(name string) (value bool, ok bool, err error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return false, false, nil
+	}
+
+	value, err = strconv.ParseBool(raw)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid value for %s: %q is not a boolean", name, raw)
+	}
+
+	return value, true, nil
+}
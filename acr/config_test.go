@@ -0,0 +1,122 @@
+package acr
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_FileWithEnvOverrides(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"transport": {"proxyUrl": "http://file-proxy:3128", "minTlsVersion": "1.3"},
+		"additionalTenants": ["tenant-a"],
+		"registries": {
+			"https://Partner.azurecr.io/": {"tenant": "tenant-b"}
+		}
+	}`)
+	t.Setenv(EnvConfigFile, path)
+	t.Setenv(EnvAnonymousPull, "partner.azurecr.io, https://public.azurecr.io/")
+	t.Setenv(EnvProxy, "http://env-proxy:3128")
+	t.Setenv(EnvMinTLSVersion, "")
+	t.Setenv(EnvAdditionalTenants, "tenant-c,tenant-a")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	if !cfg.Registry("partner.azurecr.io").AnonymousPull || !cfg.Registry("public.azurecr.io").AnonymousPull {
+		t.Errorf("expected env var to enable anonymousPull per registry, got: %+v", cfg.Registries)
+	}
+	if cfg.Transport.ProxyURL != "http://env-proxy:3128" {
+		t.Errorf("expected env proxy, got: %s", cfg.Transport.ProxyURL)
+	}
+	if cfg.Transport.MinTLSVersion != "1.3" {
+		t.Errorf("expected file value to be kept when env var is unset, got: %s", cfg.Transport.MinTLSVersion)
+	}
+	if got := cfg.Registry("partner.azurecr.io").Tenant; got != "tenant-b" {
+		t.Errorf("expected normalized registry key, got tenant %q", got)
+	}
+	if got := cfg.AllowedTenants(); !reflect.DeepEqual(got, []string{"tenant-a", "tenant-c", "tenant-b"}) {
+		t.Errorf("unexpected allowed tenants: %v", got)
+	}
+}
+
+func TestLoadConfigFile_Missing(t *testing.T) {
+	cfg, err := LoadConfigFile(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if cfg.TenantDiscovery || len(cfg.Registries) != 0 {
+		t.Errorf("expected zero configuration, got: %+v", cfg)
+	}
+}
+
+func TestLoadConfigFile_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "unknown key", content: `{"anonymous": true}`, want: "unknown field"},
+		{name: "non-ACR registry", content: `{"registries": {"gcr.io": {}}}`, want: "not an ACR registry"},
+		{
+			name:    "duplicate registry",
+			content: `{"registries": {"myregistry.azurecr.io": {}, "https://myregistry.azurecr.io": {}}}`,
+			want:    "configured more than once",
+		},
+		{
+			name:    "unsupported token source",
+			content: `{"registries": {"myregistry.azurecr.io": {"token": {"name": "partner", "source": "vault", "value": "x"}}}}`,
+			want:    "unsupported token source",
+		},
+		{
+			name:    "invalid resource ID",
+			content: `{"registries": {"legacy.azurecr.io": {"adminCredentials": true, "resourceId": "/subscriptions/sub/legacy"}}}`,
+			want:    "invalid resourceId",
+		},
+		{
+			name:    "token with admin credentials",
+			content: `{"registries": {"legacy.azurecr.io": {"adminCredentials": true, "token": {"source": "store", "value": "pass"}}}}`,
+			want:    "cannot be combined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeConfigFile(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_InvalidEnvBool(t *testing.T) {
+	t.Setenv(EnvConfigFile, filepath.Join(t.TempDir(), "missing.json"))
+	t.Setenv(EnvTenantDiscovery, "maybe")
+
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), EnvTenantDiscovery) {
+		t.Errorf("expected invalid boolean error, got: %v", err)
+	}
+}
+
+func TestLoadConfig_InvalidAnonymousPullRegistry(t *testing.T) {
+	t.Setenv(EnvConfigFile, filepath.Join(t.TempDir(), "missing.json"))
+	t.Setenv(EnvAnonymousPull, "true")
+
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), EnvAnonymousPull) {
+		t.Errorf("expected invalid registry error, got: %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+package acr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	subscriptionsAPIVersion = "2022-12-01"
+	permissionsAPIVersion   = "2022-04-01"
+)
+
+// registryOperation is a registry operation granted either by a classic
+// control plane action (AcrPull, AcrPush) or, on ABAC-enabled registries, by a
+// repository data action
+type registryOperation struct {
+	action     string
+	dataAction string
+}
+
+// Operations checked to estimate pull and push rights
+var (
+	pullOperation = registryOperation{
+		action:     "Microsoft.ContainerRegistry/registries/pull/read",
+		dataAction: "Microsoft.ContainerRegistry/registries/repositories/content/read",
+	}
+	pushOperation = registryOperation{
+		action:     "Microsoft.ContainerRegistry/registries/push/write",
+		dataAction: "Microsoft.ContainerRegistry/registries/repositories/content/write",
+	}
+)
+
+// DiscoveredRegistry describes a registry found in an Azure subscription
+type DiscoveredRegistry struct {
+	LoginServer    string `json:"loginServer"`
+	Name           string `json:"name"`
+	ResourceID     string `json:"resourceId"`
+	SubscriptionID string `json:"subscriptionId"`
+	ResourceGroup  string `json:"resourceGroup"`
+	Location       string `json:"location"`
+	SKU            string `json:"sku"`
+
+	// Pull and Push report whether the identity's effective permissions on
+	// the registry appear to include these operations
+	Pull bool `json:"pull"`
+	Push bool `json:"push"`
+}
+
+// RegistryDiscoverer is implemented by authenticators that can list the
+// registries visible to the identity
+type RegistryDiscoverer interface {
+	// DiscoverRegistries lists registries in the given subscriptions, or in
+	// all accessible subscriptions when none are given
+	DiscoverRegistries(subscriptions []string) ([]DiscoveredRegistry, error)
+}
+
+// DiscoverRegistries lists the registries visible to the identity
+func (h *ACRHelper) DiscoverRegistries(subscriptions []string) ([]DiscoveredRegistry, error) {
+	discoverer, ok := h.authenticator.(RegistryDiscoverer)
+	if !ok {
+		return nil, fmt.Errorf("authenticator cannot discover registries")
+	}
+
+	registries, err := discoverer.DiscoverRegistries(subscriptions)
+	if err != nil {
+		return nil, WrapAzureAuthError(err)
+	}
+	return registries, nil
+}
+
+// DiscoverRegistries queries ARM for the subscriptions and container
+// registries visible to the identity and their effective permissions
+func (a *AzureAuthenticator) DiscoverRegistries(subscriptions []string) ([]DiscoveredRegistry, error) {
+	managementToken, err := a.GetAzureAccessTokenFor(a.managementScope(), "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subscriptions) == 0 {
+		if subscriptions, err = a.listSubscriptions(managementToken); err != nil {
+			return nil, err
+		}
+	}
+
+	var registries []DiscoveredRegistry
+	for _, subscription := range subscriptions {
+		found, err := a.listRegistries(managementToken, subscription)
+		if err != nil {
+			return nil, err
+		}
+		registries = append(registries, found...)
+	}
+
+	for i := range registries {
+		actions, err := a.registryPermissions(managementToken, registries[i].ResourceID)
+		if err != nil {
+			// Missing read access on permissions leaves pull/push unknown (false)
+			continue
+		}
+		registries[i].Pull = actions.allows(pullOperation)
+		registries[i].Push = actions.allows(pushOperation)
+	}
+
+	sort.Slice(registries, func(i, j int) bool {
+		return registries[i].LoginServer < registries[j].LoginServer
+	})
+
+	return registries, nil
+}
+
+// armPage is a page of an ARM list response
+type armPage[T any] struct {
+	Value    []T    `json:"value"`
+	NextLink string `json:"nextLink"`
+}
+
+// armList follows nextLink and returns all items of an ARM list operation.
+// Next links must point to the configured ARM endpoint.
+func armList[T any](a *AzureAuthenticator, managementToken, path string) ([]T, error) {
+	var items []T
+
+	requestURL := a.armEndpointURL() + path
+	for requestURL != "" {
+		var page armPage[T]
+		if err := a.armRequest("GET", managementToken, requestURL, nil, &page); err != nil {
+			return nil, err
+		}
+		items = append(items, page.Value...)
+
+		requestURL = page.NextLink
+		if requestURL != "" && !strings.HasPrefix(requestURL, a.armEndpointURL()+"/") {
+			return nil, fmt.Errorf("ARM returned next link %q outside of %s", requestURL, a.armEndpointURL())
+		}
+	}
+
+	return items, nil
+}
+
+func (a *AzureAuthenticator) listSubscriptions(managementToken string) ([]string, error) {
+	subscriptions, err := armList[struct {
+		SubscriptionID string `json:"subscriptionId"`
+		State          string `json:"state"`
+	}](a, managementToken, "/subscriptions?api-version="+subscriptionsAPIVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+
+	var ids []string
+	for _, subscription := range subscriptions {
+		if subscription.State == "" || strings.EqualFold(subscription.State, "Enabled") {
+			ids = append(ids, subscription.SubscriptionID)
+		}
+	}
+	return ids, nil
+}
+
+var resourceGroupRegex = regexp.MustCompile(`(?i)/resourceGroups/([^/]+)/`)
+
+func (a *AzureAuthenticator) listRegistries(managementToken, subscription string) ([]DiscoveredRegistry, error) {
+	path := "/subscriptions/" + url.PathEscape(subscription) +
+		"/providers/Microsoft.ContainerRegistry/registries?api-version=" + registryAPIVersion
+
+	resources, err := armList[struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Location string `json:"location"`
+		SKU      struct {
+			Name string `json:"name"`
+		} `json:"sku"`
+		Properties struct {
+			LoginServer string `json:"loginServer"`
+		} `json:"properties"`
+	}](a, managementToken, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list registries in subscription %s: %w", subscription, err)
+	}
+
+	registries := make([]DiscoveredRegistry, 0, len(resources))
+	for _, resource := range resources {
+		if !registryResourceIDRegex.MatchString(resource.ID) || resource.Properties.LoginServer == "" {
+			continue
+		}
+
+		var resourceGroup string
+		if match := resourceGroupRegex.FindStringSubmatch(resource.ID); match != nil {
+			resourceGroup = match[1]
+		}
+
+		registries = append(registries, DiscoveredRegistry{
+			LoginServer:    strings.ToLower(resource.Properties.LoginServer),
+			Name:           resource.Name,
+			ResourceID:     resource.ID,
+			SubscriptionID: subscription,
+			ResourceGroup:  resourceGroup,
+			Location:       resource.Location,
+			SKU:            resource.SKU.Name,
+		})
+	}
+
+	return registries, nil
+}
+
+// armPermission is an entry of the effective permissions on a resource
+type armPermission struct {
+	Actions        []string `json:"actions"`
+	NotActions     []string `json:"notActions"`
+	DataActions    []string `json:"dataActions"`
+	NotDataActions []string `json:"notDataActions"`
+}
+
+// permissionSet holds the effective permissions of the identity on a resource
+type permissionSet []armPermission
+
+func (a *AzureAuthenticator) registryPermissions(managementToken, resourceID string) (permissionSet, error) {
+	return armList[armPermission](a, managementToken,
+		resourceID+"/providers/Microsoft.Authorization/permissions?api-version="+permissionsAPIVersion)
+}
+
+// allows reports whether any permission grants the operation without
+// excluding it
+func (p permissionSet) allows(operation registryOperation) bool {
+	for _, permission := range p {
+		if matchesAnyOperation(permission.Actions, operation.action) &&
+			!matchesAnyOperation(permission.NotActions, operation.action) {
+			return true
+		}
+		if matchesAnyOperation(permission.DataActions, operation.dataAction) &&
+			!matchesAnyOperation(permission.NotDataActions, operation.dataAction) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyOperation matches an operation against Azure RBAC action
+// patterns, which are case-insensitive and may contain '*' wildcards
+func matchesAnyOperation(patterns []string, operation string) bool {
+	for _, pattern := range patterns {
+		expr := "(?i)^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+		if matched, _ := regexp.MatchString(expr, operation); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// AddRegistriesToConfigFile adds discovered registries missing from the
+// helper configuration file, recording their resource ID. Existing entries
+// and other settings are kept; their keys are parsed with validator. It
+// returns the added login servers.
+func AddRegistriesToConfigFile(path string, registries []DiscoveredRegistry, validator *RegistryValidator) ([]string, error) {
+	file, err := loadJSONObjectFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]json.RawMessage{}
+	if _, err := file.get("registries", &existing); err != nil {
+		return nil, err
+	}
+
+	// Existing keys may use any accepted registry form
+	configured := map[string]bool{}
+	for key := range existing {
+		if host, _, err := validator.ParseAndNormalize(key); err == nil {
+			configured[host] = true
+		}
+	}
+
+	var added []string
+	for _, registry := range registries {
+		if configured[registry.LoginServer] {
+			continue
+		}
+		if _, _, err := validator.ParseAndNormalize(registry.LoginServer); err != nil {
+			continue
+		}
+		entry, err := json.Marshal(RegistryConfig{ResourceID: registry.ResourceID})
+		if err != nil {
+			return nil, err
+		}
+		existing[registry.LoginServer] = entry
+		configured[registry.LoginServer] = true
+		added = append(added, registry.LoginServer)
+	}
+
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	if err := file.set("registries", existing); err != nil {
+		return nil, err
+	}
+	return added, file.save()
+}
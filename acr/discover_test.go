@@ -0,0 +1,161 @@
+package acr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiscoverRegistries(t *testing.T) {
+	const (
+		prodID = "/subscriptions/sub-b/resourceGroups/prod-rg/providers/Microsoft.ContainerRegistry/registries/prod"
+		devID  = "/subscriptions/sub-a/resourceGroups/dev-rg/providers/Microsoft.ContainerRegistry/registries/dev"
+	)
+
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer management-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/subscriptions":
+			if r.URL.Query().Get("page") == "" {
+				w.Write([]byte(`{"value":[{"subscriptionId":"sub-a","state":"Enabled"},{"subscriptionId":"disabled","state":"Disabled"}],` +
+					`"nextLink":"` + server.URL + `/subscriptions?page=2"}`))
+				return
+			}
+			w.Write([]byte(`{"value":[{"subscriptionId":"sub-b","state":"Enabled"}]}`))
+		case "/subscriptions/sub-a/providers/Microsoft.ContainerRegistry/registries":
+			w.Write([]byte(`{"value":[{"id":"` + devID + `","name":"dev","location":"westeurope","sku":{"name":"Basic"},"properties":{"loginServer":"devregistry.azurecr.io"}}]}`))
+		case "/subscriptions/sub-b/providers/Microsoft.ContainerRegistry/registries":
+			w.Write([]byte(`{"value":[{"id":"` + prodID + `","name":"prod","location":"eastus","sku":{"name":"Premium"},"properties":{"loginServer":"Prodregistry.azurecr.io"}}]}`))
+		case devID + "/providers/Microsoft.Authorization/permissions":
+			w.Write([]byte(`{"value":[{"actions":["*"],"notActions":[],"dataActions":[],"notDataActions":[]}]}`))
+		case prodID + "/providers/Microsoft.Authorization/permissions":
+			w.Write([]byte(`{"value":[{"actions":["Microsoft.ContainerRegistry/registries/pull/read"],"dataActions":[]}]}`))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	auth.credential = &staticCredential{}
+	auth.armEndpoint = server.URL
+
+	registries, err := auth.DiscoverRegistries(nil)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	want := []DiscoveredRegistry{
+		{LoginServer: "devregistry.azurecr.io", Name: "dev", ResourceID: devID, SubscriptionID: "sub-a",
+			ResourceGroup: "dev-rg", Location: "westeurope", SKU: "Basic", Pull: true, Push: true},
+		{LoginServer: "prodregistry.azurecr.io", Name: "prod", ResourceID: prodID, SubscriptionID: "sub-b",
+			ResourceGroup: "prod-rg", Location: "eastus", SKU: "Premium", Pull: true},
+	}
+	if !reflect.DeepEqual(registries, want) {
+		t.Errorf("unexpected registries:\n got: %+v\nwant: %+v", registries, want)
+	}
+}
+
+func TestDiscoverRegistries_RejectsForeignNextLink(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"value":[],"nextLink":"https://attacker.example/subscriptions"}`))
+	}))
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	auth.credential = &staticCredential{}
+	auth.armEndpoint = server.URL
+
+	if _, err := auth.DiscoverRegistries(nil); err == nil || !strings.Contains(err.Error(), "outside of") {
+		t.Errorf("expected next link to be rejected, got: %v", err)
+	}
+}
+
+func TestPermissionSetAllows(t *testing.T) {
+	tests := []struct {
+		name       string
+		permission armPermission
+		pull, push bool
+	}{
+		{"owner", armPermission{Actions: []string{"*"}}, true, true},
+		{"reader", armPermission{Actions: []string{"*/read"}}, true, false},
+		{"acr push", armPermission{Actions: []string{
+			"microsoft.containerregistry/registries/pull/read",
+			"microsoft.containerregistry/registries/push/write",
+		}}, true, true},
+		{"abac reader", armPermission{DataActions: []string{
+			"Microsoft.ContainerRegistry/registries/repositories/content/read",
+		}}, true, false},
+		{"excluded", armPermission{
+			Actions:    []string{"Microsoft.ContainerRegistry/*"},
+			NotActions: []string{"Microsoft.ContainerRegistry/registries/push/write"},
+		}, true, false},
+		{"unrelated", armPermission{Actions: []string{"Microsoft.Storage/*"}}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := permissionSet{tt.permission}
+			if got := set.allows(pullOperation); got != tt.pull {
+				t.Errorf("pull: expected %v, got %v", tt.pull, got)
+			}
+			if got := set.allows(pushOperation); got != tt.push {
+				t.Errorf("push: expected %v, got %v", tt.push, got)
+			}
+		})
+	}
+}
+
+func TestAddRegistriesToConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	original := `{
+  "tenantDiscovery": true,
+  "registries": {
+    "https://devregistry.azurecr.io": {"tenant": "other"}
+  }
+}
+`
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	prodID := "/subscriptions/s/resourceGroups/g/providers/Microsoft.ContainerRegistry/registries/prod"
+	added, err := AddRegistriesToConfigFile(path, []DiscoveredRegistry{
+		{LoginServer: "devregistry.azurecr.io", ResourceID: "ignored"},
+		{LoginServer: "prodregistry.azurecr.io", ResourceID: prodID},
+	}, NewRegistryValidator())
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(added, []string{"prodregistry.azurecr.io"}) {
+		t.Errorf("expected only prod to be added, got: %v", added)
+	}
+
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("updated config must stay loadable: %v", err)
+	}
+	if !cfg.TenantDiscovery || cfg.Registry("devregistry.azurecr.io").Tenant != "other" {
+		t.Errorf("existing settings were not preserved: %+v", cfg)
+	}
+	if cfg.Registry("prodregistry.azurecr.io").ResourceID != prodID {
+		t.Errorf("expected resource ID for prod, got: %+v", cfg.Registry("prodregistry.azurecr.io"))
+	}
+
+	added, err = AddRegistriesToConfigFile(path, []DiscoveredRegistry{{LoginServer: "prodregistry.azurecr.io", ResourceID: prodID}}, NewRegistryValidator())
+	if err != nil || len(added) != 0 {
+		t.Errorf("expected no changes on second run, got %v, %v", added, err)
+	}
+}
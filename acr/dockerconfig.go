@@ -0,0 +1,132 @@
+package acr
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Name under which Docker finds this helper (docker-credential-<name>)
+const HelperName = "acr"
+
+// DockerConfig is a Docker CLI config.json. Only credsStore and credHelpers
+// are modified; all other keys are preserved.
+type DockerConfig struct {
+	file        *jsonObjectFile
+	credHelpers map[string]string
+	credsStore  string
+}
+
+// LoadDockerConfig reads a Docker config.json; a missing file yields an empty one
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	file, err := loadJSONObjectFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &DockerConfig{file: file, credHelpers: map[string]string{}}
+	if _, err := file.get("credHelpers", &cfg.credHelpers); err != nil {
+		return nil, err
+	}
+	if cfg.credHelpers == nil {
+		cfg.credHelpers = map[string]string{}
+	}
+	if _, err := file.get("credsStore", &cfg.credsStore); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Path returns the location of the config file
+func (c *DockerConfig) Path() string {
+	return c.file.path
+}
+
+// SetCredHelper registers this helper for host; it reports whether the
+// configuration changed
+func (c *DockerConfig) SetCredHelper(host string) bool {
+	if c.credHelpers[host] == HelperName {
+		return false
+	}
+	c.credHelpers[host] = HelperName
+	return true
+}
+
+// RemoveCredHelper unregisters this helper for host. Entries pointing to
+// other helpers are left alone.
+func (c *DockerConfig) RemoveCredHelper(host string) bool {
+	if c.credHelpers[host] != HelperName {
+		return false
+	}
+	delete(c.credHelpers, host)
+	return true
+}
+
+// SetCredsStore makes this helper the default credential store
+func (c *DockerConfig) SetCredsStore() bool {
+	if c.credsStore == HelperName {
+		return false
+	}
+	c.credsStore = HelperName
+	return true
+}
+
+// RemoveCredsStore clears credsStore if it points to this helper
+func (c *DockerConfig) RemoveCredsStore() bool {
+	if c.credsStore != HelperName {
+		return false
+	}
+	c.credsStore = ""
+	return true
+}
+
+// Marshal renders the updated configuration
+func (c *DockerConfig) Marshal() ([]byte, error) {
+	if err := c.sync(); err != nil {
+		return nil, err
+	}
+	return c.file.marshal()
+}
+
+// Save writes the updated configuration atomically. An existing file is first
+// copied to <path>.bak; the backup path is returned (empty if there was no
+// file to back up).
+func (c *DockerConfig) Save() (string, error) {
+	if err := c.sync(); err != nil {
+		return "", err
+	}
+
+	backupPath := ""
+	original, err := os.ReadFile(filepath.Clean(c.file.path))
+	switch {
+	case err == nil:
+		backupPath = c.file.path + ".bak"
+		if err := WriteFileAtomic(backupPath, original, 0o600); err != nil {
+			return "", fmt.Errorf("failed to create backup: %w", err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return "", fmt.Errorf("failed to read %s: %w", c.file.path, err)
+	}
+
+	return backupPath, c.file.save()
+}
+
+// sync writes the helper settings back into the underlying JSON object
+func (c *DockerConfig) sync() error {
+	if len(c.credHelpers) > 0 {
+		if err := c.file.set("credHelpers", c.credHelpers); err != nil {
+			return err
+		}
+	} else {
+		c.file.remove("credHelpers")
+	}
+
+	if c.credsStore != "" {
+		return c.file.set("credsStore", c.credsStore)
+	}
+	c.file.remove("credsStore")
+	return nil
+}
@@ -0,0 +1,96 @@
+package acr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerConfig_SetAndRemoveCredHelper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	original := "{\n    \"auths\": {},\n    \"credHelpers\": {\n        \"gcr.io\": \"gcloud\"\n    }\n}\n"
+	if err := os.WriteFile(path, []byte(original), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadDockerConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !cfg.SetCredHelper("myregistry.azurecr.io") {
+		t.Error("expected configuration to change")
+	}
+	if cfg.SetCredHelper("myregistry.azurecr.io") {
+		t.Error("expected second registration to be a no-op")
+	}
+
+	backup, err := cfg.Save()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if backup != path+".bak" {
+		t.Errorf("unexpected backup path: %s", backup)
+	}
+	if data, _ := os.ReadFile(backup); string(data) != original {
+		t.Errorf("expected backup to contain the original file, got:\n%s", data)
+	}
+
+	data, _ := os.ReadFile(path)
+	want := "{\n    \"auths\": {},\n    \"credHelpers\": {\n        \"gcr.io\": \"gcloud\",\n" +
+		"        \"myregistry.azurecr.io\": \"acr\"\n    }\n}\n"
+	if string(data) != want {
+		t.Errorf("unexpected config:\n%s\nwant:\n%s", data, want)
+	}
+
+	cfg, err = LoadDockerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.RemoveCredHelper("myregistry.azurecr.io") {
+		t.Error("expected helper to be removed")
+	}
+	if cfg.RemoveCredHelper("gcr.io") {
+		t.Error("expected entries of other helpers to be left alone")
+	}
+}
+
+func TestDockerConfig_CredsStore(t *testing.T) {
+	cfg, err := LoadDockerConfig(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.SetCredsStore()
+	data, err := cfg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\n\t\"credsStore\": \"acr\"\n}\n" {
+		t.Errorf("unexpected config:\n%s", data)
+	}
+
+	cfg.RemoveCredsStore()
+	data, err = cfg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "credsStore") {
+		t.Errorf("expected credsStore to be removed, got:\n%s", data)
+	}
+}
+
+func TestDockerConfig_RemoveCredsStoreKeepsOtherHelper(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"credsStore":"desktop"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadDockerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RemoveCredsStore() {
+		t.Error("expected credsStore of another helper to be kept")
+	}
+}
@@ -2,6 +2,7 @@ package acr
 
 import (
 	"fmt"
+	"time"
 )
 
 // Error types for different failure scenarios
@@ -51,6 +52,10 @@ func (e *AzureAuthError) Error() string {
 	)
 }
 
+func (e *AzureAuthError) Unwrap() error {
+	return e.Cause
+}
+
 func WrapAzureAuthError(err error) error {
 	return &AzureAuthError{Cause: err}
 }
@@ -69,6 +74,73 @@ func (e *ACRTokenExchangeError) Error() string {
 	)
 }
 
+func (e *ACRTokenExchangeError) Unwrap() error {
+	return e.Cause
+}
+
 func WrapACRTokenExchangeError(err error) error {
 	return &ACRTokenExchangeError{Cause: err}
 }
+
+// ExchangeStatusError is an exchange request rejected by the registry
+type ExchangeStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *ExchangeStatusError) Error() string {
+	return fmt.Sprintf("ACR token exchange failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// CachedFailureError is a recent failure returned again without contacting
+// Azure AD or the registry
+type CachedFailureError struct {
+	Message string
+	RetryAt time.Time
+}
+
+func (e *CachedFailureError) Error() string {
+	return fmt.Sprintf(
+		"%s (cached failure, retrying after %s; run 'docker-credential-acr clear-failures <registry>' or set %s=0 to retry now)",
+		e.Message,
+		e.RetryAt.Local().Format(time.TimeOnly),
+		EnvFailureCacheTTL,
+	)
+}
+
+// ScopeMapTokenError wraps failures reading a repository-scoped token
+type ScopeMapTokenError struct {
+	Registry string
+	Cause    error
+}
+
+func (e *ScopeMapTokenError) Error() string {
+	return fmt.Sprintf(
+		"Failed to read the ACR token configured for %s: %v. "+
+			"Verify the registry's token source in the configuration file.",
+		e.Registry,
+		e.Cause,
+	)
+}
+
+func WrapScopeMapTokenError(registry string, err error) error {
+	return &ScopeMapTokenError{Registry: registry, Cause: err}
+}
+
+// AdminCredentialsError wraps failures reading admin credentials from ARM
+type AdminCredentialsError struct {
+	Cause error
+}
+
+func (e *AdminCredentialsError) Error() string {
+	return fmt.Sprintf(
+		"Failed to read registry admin credentials: %v. "+
+			"Verify that the admin user is enabled and that your identity may "+
+			"perform Microsoft.ContainerRegistry/registries/listCredentials/action.",
+		e.Cause,
+	)
+}
+
+func WrapAdminCredentialsError(err error) error {
+	return &AdminCredentialsError{Cause: err}
+}
@@ -0,0 +1,222 @@
+package acr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// DefaultFailureCacheTTL is how long classified failures are cached unless
+// configured otherwise
+const DefaultFailureCacheTTL = 30 * time.Second
+
+// cachedFailure is the cached form of a failed credential request
+type cachedFailure struct {
+	Message string    `json:"message"`
+	RetryAt time.Time `json:"retryAt"`
+}
+
+// Directory below the cache directory holding failures when there is no
+// persistent token cache
+const failureCacheDir = "failures"
+
+// nonRetriable is implemented by azidentity errors that retrying does not
+// resolve, among them the unexported "credential unavailable" error
+type nonRetriable interface {
+	NonRetriable()
+}
+
+// ParseFailureCacheTTL parses the failureCacheTTL setting; empty selects
+// DefaultFailureCacheTTL and zero disables the failure cache
+func ParseFailureCacheTTL(raw string) (time.Duration, error) {
+	if raw == "" {
+		return DefaultFailureCacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid failureCacheTTL %q: expected a duration such as 30s, or 0 to disable", raw)
+	}
+	return ttl, nil
+}
+
+// isPersistentFailure reports whether a credential request failed in a way
+// an immediate retry will not fix: the registry rejecting the identity, no
+// usable Azure credential, or a missing tenant. Network errors and timeouts
+// are never cached.
+func isPersistentFailure(err error) bool {
+	var status *ExchangeStatusError
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusUnauthorized || status.StatusCode == http.StatusForbidden
+	}
+
+	var authFailed *azidentity.AuthenticationFailedError
+	if errors.As(err, &authFailed) {
+		// Without a response the failure came from e.g. the Azure CLI
+		if authFailed.RawResponse == nil {
+			return false
+		}
+		switch authFailed.RawResponse.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+			return true
+		}
+		return false
+	}
+
+	var missingTenant *MissingTenantIDError
+	var unavailable nonRetriable
+	return errors.As(err, &missingTenant) || errors.As(err, &unavailable) || errors.Is(err, ErrLoginRequired)
+}
+
+// ClearFailure forgets the cached failure of a registry, so the next request
+// retries immediately
+func (h *ACRHelper) ClearFailure(serverURL string) error {
+	registryHost, _, err := h.validator.ParseAndNormalize(serverURL)
+	if err != nil {
+		return err
+	}
+	if h.failures == nil {
+		return nil
+	}
+	return h.failures.Delete(h.failureCacheKey(registryHost))
+}
+
+// failureCacheKey identifies the cached failure of a registry and identity
+func (h *ACRHelper) failureCacheKey(registryHost string) string {
+	return "failure/" + h.refreshTokenCacheKey(registryHost)
+}
+
+// cachedFailureError returns the failure cached for the registry, or nil
+func (h *ACRHelper) cachedFailureError(registryHost string) error {
+	if h.failures == nil || h.failureTTL <= 0 {
+		return nil
+	}
+
+	key := h.failureCacheKey(registryHost)
+	data, err := h.failures.Get(key)
+	if err != nil {
+		if errors.Is(err, ErrCacheTampered) {
+			_ = h.failures.Delete(key)
+		}
+		return nil
+	}
+
+	var failure cachedFailure
+	if err := json.Unmarshal(data, &failure); err != nil || failure.Message == "" {
+		_ = h.failures.Delete(key)
+		return nil
+	}
+
+	return &CachedFailureError{Message: failure.Message, RetryAt: failure.RetryAt}
+}
+
+// recordFailure caches persistent failures for failureTTL and forgets
+// earlier failures once a request succeeds, also when the failure cache was
+// bypassed
+func (h *ACRHelper) recordFailure(registryHost string, err error) {
+	if h.failures == nil {
+		return
+	}
+
+	key := h.failureCacheKey(registryHost)
+	if err == nil {
+		_ = h.failures.Delete(key)
+		return
+	}
+	if h.failureTTL <= 0 || !isPersistentFailure(err) {
+		return
+	}
+
+	data, marshalErr := json.Marshal(cachedFailure{Message: err.Error(), RetryAt: time.Now().Add(h.failureTTL)})
+	if marshalErr != nil {
+		return
+	}
+	_ = h.failures.Set(key, data, h.failureTTL)
+}
+
+// failureFileStore keeps failures in plain files when the token cache does
+// not outlive the process. Failures hold no secrets, so unlike token cache
+// entries they are not encrypted.
+type failureFileStore struct {
+	dir string
+}
+
+// failureFileEntry is the on-disk form of a failure file entry
+type failureFileEntry struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+	Value     []byte    `json:"value"`
+}
+
+func newFailureFileStore(cfg CacheConfig) (CacheStore, error) {
+	dir, err := cacheDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, failureCacheDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create failure cache directory: %w", err)
+	}
+	return &failureFileStore{dir: dir}, nil
+}
+
+// entryPath maps a key to a file name that does not reveal the registry
+func (f *failureFileStore) entryPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (f *failureFileStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(f.entryPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failure cache entry: %w", err)
+	}
+
+	var entry failureFileEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, ErrCacheTampered
+	}
+	if !time.Now().Before(entry.ExpiresAt) {
+		_ = f.Delete(key)
+		return nil, ErrCacheMiss
+	}
+	return entry.Value, nil
+}
+
+func (f *failureFileStore) Set(key string, value []byte, ttl time.Duration) error {
+	data, err := json.Marshal(failureFileEntry{ExpiresAt: time.Now().Add(ttl), Value: value})
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(f.entryPath(key), data, 0o600)
+}
+
+func (f *failureFileStore) Delete(key string) error {
+	if err := os.Remove(f.entryPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove failure cache entry: %w", err)
+	}
+	return nil
+}
+
+func (f *failureFileStore) Clear() error {
+	entries, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Remove(entry); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove failure cache entry: %w", err)
+		}
+	}
+	return nil
+}
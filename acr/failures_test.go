@@ -0,0 +1,196 @@
+package acr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+func TestIsPersistentFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"exchange unauthorized", WrapACRTokenExchangeError(&ExchangeStatusError{StatusCode: 401}), true},
+		{"exchange forbidden", WrapACRTokenExchangeError(&ExchangeStatusError{StatusCode: 403}), true},
+		{"exchange server error", WrapACRTokenExchangeError(&ExchangeStatusError{StatusCode: 503}), false},
+		{"exchange network error", WrapACRTokenExchangeError(errors.New("connection refused")), false},
+		{"missing tenant", NewMissingTenantIDError(), true},
+		{"login required", WrapAzureAuthError(fmt.Errorf("get token: %w", ErrLoginRequired)), true},
+		{"rejected by Azure AD", WrapAzureAuthError(&azidentity.AuthenticationFailedError{RawResponse: &http.Response{StatusCode: 401}}), true},
+		{"Azure AD unavailable", WrapAzureAuthError(&azidentity.AuthenticationFailedError{RawResponse: &http.Response{StatusCode: 503}}), false},
+		{"Azure CLI failure", WrapAzureAuthError(&azidentity.AuthenticationFailedError{}), false},
+		{"timeout", WrapAzureAuthError(errors.New("context deadline exceeded")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPersistentFailure(tt.err); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseFailureCacheTTL(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultFailureCacheTTL, false},
+		{"0", 0, false},
+		{"2m", 2 * time.Minute, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseFailureCacheTTL(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFailureCacheTTL(%q) = %v, %v", tt.raw, got, err)
+		}
+	}
+}
+
+func TestGet_CachesPersistentFailures(t *testing.T) {
+	auth := &countingAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	auth.refreshTokenErr = &ExchangeStatusError{StatusCode: 403, Body: "denied"}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.failures = NewMemoryCacheStore()
+	helper.failureTTL = time.Minute
+
+	// countingAuthenticator only fails for this registry
+	const registry = "failing.azurecr.io"
+
+	_, _, err := helper.Get(registry)
+	var exchangeErr *ACRTokenExchangeError
+	if !errors.As(err, &exchangeErr) {
+		t.Fatalf("expected exchange error, got: %v", err)
+	}
+
+	// The identity's permissions were fixed, but the failure is still cached
+	auth.refreshTokenErr = nil
+	_, _, err = helper.Get("https://" + registry)
+	var cached *CachedFailureError
+	if !errors.As(err, &cached) {
+		t.Fatalf("expected cached failure, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "status 403") || !strings.Contains(err.Error(), EnvFailureCacheTTL+"=0") {
+		t.Errorf("expected original error and retry note, got: %v", err)
+	}
+	if until := time.Until(cached.RetryAt); until <= 0 || until > time.Minute {
+		t.Errorf("unexpected retry time %v", cached.RetryAt)
+	}
+	if got := auth.exchangeCalls.Load(); got != 1 {
+		t.Errorf("expected cached failure not to reach the registry, got %d exchanges", got)
+	}
+
+	// Other registries are not affected
+	if _, _, err := helper.Get("myregistry.azurecr.io"); err != nil {
+		t.Errorf("expected other registry to succeed, got: %v", err)
+	}
+
+	// Disabling the failure cache retries and a success clears the failure
+	helper.failureTTL = 0
+	if _, _, err := helper.Get(registry); err != nil {
+		t.Fatalf("expected retry to succeed, got: %v", err)
+	}
+	helper.failureTTL = time.Minute
+	if _, _, err := helper.Get(registry); err != nil {
+		t.Errorf("expected success to clear the cached failure, got: %v", err)
+	}
+
+	// Clearing the failure retries at once
+	auth.refreshTokenErr = &ExchangeStatusError{StatusCode: 403, Body: "denied"}
+	helper.Get(registry)
+	auth.refreshTokenErr = nil
+	if err := helper.ClearFailure("https://" + registry); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := helper.Get(registry); err != nil {
+		t.Errorf("expected cleared failure to be retried, got: %v", err)
+	}
+}
+
+func TestGet_DoesNotCacheTransientFailures(t *testing.T) {
+	auth := &countingAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	auth.refreshTokenErr = errors.New("connection reset by peer")
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.failures = NewMemoryCacheStore()
+	helper.failureTTL = time.Minute
+
+	for range 2 {
+		_, _, err := helper.Get("failing.azurecr.io")
+		var cached *CachedFailureError
+		if err == nil || errors.As(err, &cached) {
+			t.Fatalf("expected uncached failure, got: %v", err)
+		}
+	}
+	if got := auth.exchangeCalls.Load(); got != 2 {
+		t.Errorf("expected every request to reach the registry, got %d exchanges", got)
+	}
+}
+
+func TestNewACRHelperWithConfig_FailureCacheTTL(t *testing.T) {
+	t.Setenv(EnvCachePassphrase, "correct horse")
+	dir := t.TempDir()
+	helper, err := NewACRHelperWithConfig(&Config{Cache: CacheConfig{Backend: CacheBackendFile, Dir: dir, Key: CacheKeyPassphrase}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if helper.failureTTL != DefaultFailureCacheTTL || helper.failures != helper.cache {
+		t.Errorf("expected default failure cache in the token cache, got %v, %T", helper.failureTTL, helper.failures)
+	}
+
+	// Failures outlive the process without a persistent token cache too
+	for _, backend := range []string{CacheBackendNone, CacheBackendMemory} {
+		helper, err := NewACRHelperWithConfig(&Config{Cache: CacheConfig{Backend: backend, Dir: dir}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := helper.failures.(*failureFileStore); !ok {
+			t.Errorf("%s: expected failures in files, got %T", backend, helper.failures)
+		}
+	}
+
+	if _, err := NewACRHelperWithConfig(&Config{FailureCacheTTL: "forever"}); err == nil {
+		t.Error("expected error for invalid failureCacheTTL")
+	}
+}
+
+func TestFailureFileStore(t *testing.T) {
+	store, err := newFailureFileStore(CacheConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Set("failure/key", []byte(`{"message":"denied"}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// A later process sees the failure
+	reopened := &failureFileStore{dir: store.(*failureFileStore).dir}
+	if value, err := reopened.Get("failure/key"); err != nil || string(value) != `{"message":"denied"}` {
+		t.Errorf("expected stored failure, got %q, %v", value, err)
+	}
+
+	if err := store.Set("failure/expired", []byte("{}"), -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("failure/expired"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("expected expired entry to miss, got %v", err)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("failure/key"); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("expected cleared entry to miss, got %v", err)
+	}
+}
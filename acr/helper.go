@@ -1,7 +1,14 @@
 package acr
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker-credential-helpers/credentials"
 )
@@ -13,10 +20,46 @@ type Authenticator interface {
 	ExchangeForACRToken(registryHost, tenantID, azureToken string) (string, error)
 }
 
+// ScopedTokenProvider is implemented by authenticators that can acquire Azure
+// access tokens for a specific scope and tenant
+type ScopedTokenProvider interface {
+	GetAzureAccessTokenFor(scope, tenantID string) (string, error)
+}
+
+// Username used with ACR refresh tokens
+const ACRRefreshTokenUsername = "00000000-0000-0000-0000-000000000000"
+
+// Anonymous pull decisions are kept in the token cache for this long
+const anonymousCacheTTL = time.Hour
+
 // ACRHelper implements the credentials.Helper interface for ACR
 type ACRHelper struct {
 	authenticator Authenticator
 	validator     *RegistryValidator
+	config        *Config
+
+	// cache stores refresh tokens between invocations (nil disables caching)
+	cache CacheStore
+
+	// broker fetches credentials from a serve-http broker (nil to
+	// authenticate locally)
+	broker *brokerClient
+
+	// locks serializes fetching across processes sharing a persistent cache
+	// (nil without one)
+	locks *cacheLocker
+
+	// failures caches persistent failures for failureTTL (nil or zero
+	// disables the failure cache)
+	failures   CacheStore
+	failureTTL time.Duration
+
+	// flights coalesces concurrent refresh token requests per registry and identity
+	flights flightGroup
+
+	// anonymous caches the anonymous pull decision per registry host
+	anonymousMu sync.Mutex
+	anonymous   map[string]bool
 }
 
 // NewACRHelper creates a new ACR credential helper
@@ -24,7 +67,67 @@ func NewACRHelper() *ACRHelper {
 	return &ACRHelper{
 		authenticator: NewAzureAuthenticator(),
 		validator:     NewRegistryValidator(),
+		config:        &Config{},
+	}
+}
+
+// NewACRHelperWithConfig creates a new ACR credential helper using the given configuration
+func NewACRHelperWithConfig(cfg *Config) (*ACRHelper, error) {
+	auth, err := NewAzureAuthenticatorFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := NewCacheStore(cfg.Cache)
+	if err != nil {
+		return nil, err
+	}
+	auth.cache = cache
+
+	failureTTL, err := ParseFailureCacheTTL(cfg.FailureCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Failures must survive between invocations for Docker's retries to see
+	// them. Unless the token cache does, they are kept in small files below
+	// the cache directory.
+	failures := cache
+	if _, inMemory := cache.(*memoryCacheStore); cache == nil || inMemory {
+		if store, err := newFailureFileStore(cfg.Cache); err == nil {
+			failures = store
+		}
+	}
+
+	// An in-memory cache is not shared with other processes. Locking is an
+	// optimization; without a lock directory every process fetches itself.
+	var locks *cacheLocker
+	if _, inMemory := cache.(*memoryCacheStore); cache != nil && !inMemory {
+		locks, _ = newCacheLocker(cfg.Cache)
+	}
+
+	var broker *brokerClient
+	if cfg.Broker.URL != "" {
+		if broker, err = newBrokerClient(cfg.Broker); err != nil {
+			return nil, err
+		}
+	}
+
+	var authenticator Authenticator = auth
+	if plugin, ok := auth.credential.(*ExecPlugin); ok {
+		authenticator = &PluginAuthenticator{AzureAuthenticator: auth, plugin: plugin}
 	}
+
+	return &ACRHelper{
+		authenticator: authenticator,
+		validator:     cfg.RegistryValidator(),
+		config:        cfg,
+		cache:         cache,
+		broker:        broker,
+		locks:         locks,
+		failures:      failures,
+		failureTTL:    failureTTL,
+	}, nil
 }
 
 // NewACRHelperWithAuthenticator creates an ACR credential helper with a custom authenticator (for testing)
@@ -32,35 +135,198 @@ func NewACRHelperWithAuthenticator(auth Authenticator) *ACRHelper {
 	return &ACRHelper{
 		authenticator: auth,
 		validator:     NewRegistryValidator(),
+		config:        &Config{},
 	}
 }
 
 // Get retrieves credentials for the specified server URL
 // Returns: username (null GUID), password (refresh token), error
 func (h *ACRHelper) Get(serverURL string) (string, string, error) {
+	return h.GetValidFor(serverURL, cacheExpiryMargin)
+}
+
+// GetValidFor is Get for callers that keep the credentials for a while: a
+// cached refresh token is only returned while valid for at least minValidity
+func (h *ACRHelper) GetValidFor(serverURL string, minValidity time.Duration) (string, string, error) {
 	// 1. Validate server URL is an ACR registry
 	registryHost, _, err := h.validator.ParseAndNormalize(serverURL)
 	if err != nil {
 		return "", "", err
 	}
 
-	// 2. Get Azure access token
+	// 2. Registries configured with a scope map token or admin user skip the exchange
+	if username, secret, ok, err := h.configuredCredentials(registryHost); ok {
+		return username, secret, err
+	}
+
+	// 3. Skip authentication entirely for registries allowing anonymous pulls
+	if h.config.Registry(registryHost).AnonymousPull && h.allowsAnonymousPull(registryHost) {
+		return "", "", nil
+	}
+
+	// 4. Reuse a cached refresh token or exchange a new one
+	return h.refreshToken(registryHost, minValidity, h.acquireAzureToken)
+}
+
+// refreshToken returns a cached ACR refresh token for the registry valid for
+// at least minValidity, or gets an Azure access token using acquire (for the
+// registry's tenant if known) and exchanges it for a new one. Concurrent
+// calls for the same registry and identity share one acquisition and its
+// result.
+func (h *ACRHelper) refreshToken(
+	registryHost string,
+	minValidity time.Duration,
+	acquire func(registryTenant string) (string, string, error),
+) (string, string, error) {
+	return h.flights.do(h.refreshTokenCacheKey(registryHost), func() (string, string, error) {
+		if err := h.cachedFailureError(registryHost); err != nil {
+			return "", "", err
+		}
+
+		username, secret, err := h.fetchRefreshToken(registryHost, minValidity, acquire)
+		h.recordFailure(registryHost, err)
+		return username, secret, err
+	})
+}
+
+func (h *ACRHelper) fetchRefreshToken(
+	registryHost string,
+	minValidity time.Duration,
+	acquire func(registryTenant string) (string, string, error),
+) (string, string, error) {
+	if username, secret, ok := h.cachedRefreshToken(registryHost, minValidity); ok {
+		return username, secret, nil
+	}
+
+	// Let one process fetch while others wait for it to fill the cache
+	if h.locks != nil {
+		unlock, username, secret, ok, err := h.awaitRefreshToken(registryHost, minValidity)
+		if ok {
+			return username, secret, err
+		}
+		defer unlock()
+	}
+
+	var username, secret string
+	var expiresAt time.Time
+	var err error
+	if h.broker != nil {
+		username, secret, err = h.broker.Credentials(registryHost)
+	} else if provider, ok := h.authenticator.(RegistryTokenProvider); ok {
+		username, secret, expiresAt, err = h.pluginRefreshToken(provider, registryHost)
+	} else {
+		username, secret, err = h.acquireAndExchange(registryHost, acquire)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	h.cacheRefreshToken(registryHost, username, secret, expiresAt)
+	return username, secret, nil
+}
+
+// acquireAndExchange gets an Azure access token using acquire and exchanges
+// it for ACR credentials
+func (h *ACRHelper) acquireAndExchange(
+	registryHost string,
+	acquire func(registryTenant string) (string, string, error),
+) (string, string, error) {
+	azureToken, tenantID, err := h.acquireRegistryToken(registryHost, acquire)
+	if err != nil {
+		return "", "", err
+	}
+	return h.exchange(registryHost, tenantID, azureToken)
+}
+
+// acquireRegistryToken obtains the Azure token for a registry using acquire.
+// The registry's tenant is taken from the configuration. Otherwise the home
+// tenant's token is used unless tenant discovery, enabled or tried as a last
+// resort when the home tenant cannot be determined, finds another tenant.
+func (h *ACRHelper) acquireRegistryToken(
+	registryHost string,
+	acquire func(registryTenant string) (string, string, error),
+) (string, string, error) {
+	if registryTenant := h.config.Registry(registryHost).Tenant; registryTenant != "" {
+		return acquire(registryTenant)
+	}
+
+	azureToken, tenantID, err := acquire("")
+
+	// Plugins may return an ACR refresh token instead, which is final
+	var missingTenant *MissingTenantIDError
+	if (err == nil && azureToken != "" && h.config.TenantDiscovery) || errors.As(err, &missingTenant) {
+		if discovered := h.discoverTenant(registryHost); discovered != "" && !strings.EqualFold(discovered, tenantID) {
+			if !h.config.TenantAllowed(discovered) {
+				return "", "", WrapAzureAuthError(fmt.Errorf(
+					"registry %s belongs to tenant %s, which is not allowed; set it as the registry's tenant or add it to additionalTenants (%s)",
+					registryHost, discovered, EnvAdditionalTenants))
+			}
+			return acquire(discovered)
+		}
+	}
+
+	return azureToken, tenantID, err
+}
+
+// discoverTenant returns the tenant owning the registry, or "" if unknown
+func (h *ACRHelper) discoverTenant(registryHost string) string {
+	discoverer, ok := h.authenticator.(TenantDiscoverer)
+	if !ok {
+		return ""
+	}
+
+	tenant, err := discoverer.DiscoverTenant(registryHost)
+	if err != nil {
+		return ""
+	}
+	return tenant
+}
+
+// acquireAzureToken obtains an Azure access token and determines the tenant.
+// With a registry tenant the token is requested from that tenant. Otherwise
+// the home tenant is used: the JWT 'tid' claim is tried first, then the
+// AZURE_TENANT_ID env var.
+func (h *ACRHelper) acquireAzureToken(registryTenant string) (string, string, error) {
+	if registryTenant != "" {
+		provider, ok := h.authenticator.(ScopedTokenProvider)
+		if !ok {
+			return "", "", WrapAzureAuthError(fmt.Errorf("authenticator cannot request tokens for tenant %s", registryTenant))
+		}
+
+		azureToken, err := provider.GetAzureAccessTokenFor(ACRScope, registryTenant)
+		if err != nil {
+			return "", "", WrapAzureAuthError(err)
+		}
+		return azureToken, registryTenant, nil
+	}
+
 	azureToken, err := h.authenticator.GetAzureAccessToken()
 	if err != nil {
 		return "", "", WrapAzureAuthError(err)
 	}
 
-	// 3. Determine tenant ID: try extracting from JWT first, then fall back to env var
+	tenantID, err := h.homeTenant(azureToken)
+	return azureToken, tenantID, err
+}
+
+// homeTenant returns the tenant of an Azure token from its 'tid' claim,
+// falling back to the AZURE_TENANT_ID env var
+func (h *ACRHelper) homeTenant(azureToken string) (string, error) {
 	tenantID, err := h.authenticator.ExtractTenantIDFromToken(azureToken)
 	if err != nil || tenantID == "" {
 		// Fall back to environment variable
 		tenantID = os.Getenv("AZURE_TENANT_ID")
 		if tenantID == "" {
-			return "", "", NewMissingTenantIDError()
+			return "", NewMissingTenantIDError()
 		}
 	}
+	return tenantID, nil
+}
 
-	// 4. Exchange for ACR refresh token
+// exchange trades the Azure token for ACR credentials
+// Username: null GUID (standard for ACR refresh tokens)
+// Password: ACR refresh token
+func (h *ACRHelper) exchange(registryHost, tenantID, azureToken string) (string, string, error) {
 	refreshToken, err := h.authenticator.ExchangeForACRToken(
 		registryHost,
 		tenantID,
@@ -70,10 +336,102 @@ func (h *ACRHelper) Get(serverURL string) (string, string, error) {
 		return "", "", WrapACRTokenExchangeError(err)
 	}
 
-	// 5. Return Docker credentials
-	// Username: null GUID (standard for ACR refresh tokens)
-	// Password: ACR refresh token
-	return "00000000-0000-0000-0000-000000000000", refreshToken, nil
+	return ACRRefreshTokenUsername, refreshToken, nil
+}
+
+// configuredCredentials returns the credentials of registries configured with
+// a repository-scoped token or admin credentials; ok is false for all others
+func (h *ACRHelper) configuredCredentials(registryHost string) (username, secret string, ok bool, err error) {
+	registry := h.config.Registry(registryHost)
+
+	switch {
+	case registry.Token != nil:
+		username, secret, err = h.scopeMapToken(registryHost, registry.Token)
+	case registry.AdminCredentials:
+		username, secret, err = h.adminCredentials(registryHost, registry)
+	default:
+		return "", "", false, nil
+	}
+
+	return username, secret, true, err
+}
+
+// scopeMapToken returns the token name and password of a repository-scoped token
+func (h *ACRHelper) scopeMapToken(registryHost string, token *ScopeMapTokenConfig) (string, string, error) {
+	username, password, err := token.Credentials(context.Background(), registryHost)
+	if err != nil {
+		return "", "", WrapScopeMapTokenError(registryHost, err)
+	}
+	return username, password, nil
+}
+
+// adminCredentials reads the admin user credentials of a registry from ARM,
+// or from the token cache if they were read recently
+func (h *ACRHelper) adminCredentials(registryHost string, registry RegistryConfig) (string, string, error) {
+	if username, password, ok := h.cachedAdminCredentials(registryHost); ok {
+		return username, password, nil
+	}
+
+	provider, ok := h.authenticator.(AdminCredentialProvider)
+	if !ok {
+		return "", "", WrapAdminCredentialsError(fmt.Errorf("authenticator cannot read admin credentials"))
+	}
+
+	username, password, err := provider.GetAdminCredentials(registryHost, registry.ResourceID, registry.Tenant)
+	if err != nil {
+		return "", "", WrapAdminCredentialsError(err)
+	}
+	h.cacheAdminCredentials(registryHost, username, password)
+	return username, password, nil
+}
+
+// allowsAnonymousPull reports whether the registry issues anonymous pull
+// tokens. The decision is cached per registry, in the token cache when there
+// is one; probe errors are not cached and count as "authentication required".
+func (h *ACRHelper) allowsAnonymousPull(registryHost string) bool {
+	checker, ok := h.authenticator.(AnonymousPullChecker)
+	if !ok {
+		return false
+	}
+
+	h.anonymousMu.Lock()
+	anonymous, ok := h.anonymous[registryHost]
+	h.anonymousMu.Unlock()
+	if ok {
+		return anonymous
+	}
+
+	cacheKey := "anonymous/" + registryHost
+	var cached []byte
+	if h.cache != nil {
+		cached, _ = h.cache.Get(cacheKey)
+	}
+
+	if cached != nil {
+		anonymous = string(cached) == "true"
+	} else {
+		var err error
+		if anonymous, err = checker.CheckAnonymousPull(registryHost); err != nil {
+			return false
+		}
+		if h.cache != nil {
+			_ = h.cache.Set(cacheKey, []byte(strconv.FormatBool(anonymous)), anonymousCacheTTL)
+		}
+	}
+
+	h.anonymousMu.Lock()
+	defer h.anonymousMu.Unlock()
+	if h.anonymous == nil {
+		h.anonymous = map[string]bool{}
+	}
+	h.anonymous[registryHost] = anonymous
+	return anonymous
+}
+
+// RegistryValidator returns the validator used to parse server URLs, which
+// follows the configured parsing mode
+func (h *ACRHelper) RegistryValidator() *RegistryValidator {
+	return h.validator
 }
 
 // Add is not implemented (credential storage not required)
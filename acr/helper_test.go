@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/docker/docker-credential-helpers/credentials"
@@ -215,3 +218,370 @@ func TestList_ReturnsEmptyMap(t *testing.T) {
 		t.Errorf("expected empty map, got: %v", result)
 	}
 }
+
+// fakeAnonymousAuthenticator adds anonymous pull detection to fakeAuthenticator
+type fakeAnonymousAuthenticator struct {
+	fakeAuthenticator
+	anonymous bool
+	checks    int
+}
+
+func (f *fakeAnonymousAuthenticator) CheckAnonymousPull(_ string) (bool, error) {
+	f.checks++
+	return f.anonymous, nil
+}
+
+func TestGet_AnonymousPull_ReturnsEmptyCredentials(t *testing.T) {
+	auth := &fakeAnonymousAuthenticator{
+		fakeAuthenticator: fakeAuthenticator{accessTokenErr: fmt.Errorf("no credential providers found")},
+		anonymous:         true,
+	}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.Registries = map[string]RegistryConfig{"myregistry.azurecr.io": {AnonymousPull: true}}
+
+	for i := 0; i < 2; i++ {
+		username, secret, err := helper.Get("myregistry.azurecr.io")
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if username != "" || secret != "" {
+			t.Errorf("expected empty credentials, got: %q / %q", username, secret)
+		}
+	}
+
+	if auth.checks != 1 {
+		t.Errorf("expected anonymous decision to be cached, got %d checks", auth.checks)
+	}
+}
+
+func TestGet_AnonymousPull_FallsBackToAzureAuth(t *testing.T) {
+	auth := &fakeAnonymousAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.Registries = map[string]RegistryConfig{"myregistry.azurecr.io": {AnonymousPull: true}}
+
+	_, secret, err := helper.Get("myregistry.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if secret != "fake-refresh-token-12345" {
+		t.Errorf("expected fake refresh token, got: %s", secret)
+	}
+}
+
+func TestGet_AnonymousPull_DisabledByDefault(t *testing.T) {
+	auth := &fakeAnonymousAuthenticator{fakeAuthenticator: *successAuthenticator(), anonymous: true}
+	helper := NewACRHelperWithAuthenticator(auth)
+
+	_, secret, err := helper.Get("myregistry.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if secret != "fake-refresh-token-12345" || auth.checks != 0 {
+		t.Errorf("expected Azure auth without anonymous check, got secret %q and %d checks", secret, auth.checks)
+	}
+}
+
+func TestGet_AnonymousPull_OnlyConfiguredRegistries(t *testing.T) {
+	auth := &fakeAnonymousAuthenticator{fakeAuthenticator: *successAuthenticator(), anonymous: true}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.Registries = map[string]RegistryConfig{"public.azurecr.io": {AnonymousPull: true}}
+
+	// Registries that are pushed to keep getting real credentials
+	if _, secret, err := helper.Get("myregistry.azurecr.io"); err != nil || secret != "fake-refresh-token-12345" {
+		t.Errorf("expected Azure auth for other registries, got %q, %v", secret, err)
+	}
+	if auth.checks != 0 {
+		t.Errorf("expected no anonymous check for other registries, got %d", auth.checks)
+	}
+}
+
+func TestGet_AnonymousPull_PersistsDecision(t *testing.T) {
+	cache := NewMemoryCacheStore()
+	newHelper := func() (*ACRHelper, *fakeAnonymousAuthenticator) {
+		auth := &fakeAnonymousAuthenticator{
+			fakeAuthenticator: fakeAuthenticator{accessTokenErr: fmt.Errorf("no credential providers found")},
+			anonymous:         true,
+		}
+		helper := NewACRHelperWithAuthenticator(auth)
+		helper.config.Registries = map[string]RegistryConfig{"public.azurecr.io": {AnonymousPull: true}}
+		helper.cache = cache
+		return helper, auth
+	}
+
+	first, _ := newHelper()
+	if _, secret, err := first.Get("public.azurecr.io"); err != nil || secret != "" {
+		t.Fatalf("expected empty credentials, got %q, %v", secret, err)
+	}
+
+	second, auth := newHelper()
+	if _, secret, err := second.Get("public.azurecr.io"); err != nil || secret != "" {
+		t.Fatalf("expected empty credentials, got %q, %v", secret, err)
+	}
+	if auth.checks != 0 {
+		t.Errorf("expected the cached decision to be reused, got %d checks", auth.checks)
+	}
+}
+
+// tenantAuthenticator records the tenants tokens are requested for
+type tenantAuthenticator struct {
+	fakeAuthenticator
+	mu               sync.Mutex
+	requestedTenants []string
+	exchangeTenants  []string
+}
+
+func (f *tenantAuthenticator) GetAzureAccessTokenFor(scope, tenantID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requestedTenants = append(f.requestedTenants, tenantID)
+	return "token-for-" + tenantID, nil
+}
+
+func (f *tenantAuthenticator) ExchangeForACRToken(_, tenantID, azureToken string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exchangeTenants = append(f.exchangeTenants, tenantID)
+	return "refresh-" + azureToken, nil
+}
+
+func TestGet_UsesConfiguredRegistryTenant(t *testing.T) {
+	auth := &tenantAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.Registries = map[string]RegistryConfig{
+		"partner.azurecr.io": {Tenant: "partner-tenant"},
+	}
+
+	_, secret, err := helper.Get("partner.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if secret != "refresh-token-for-partner-tenant" {
+		t.Errorf("expected token from the partner tenant, got: %s", secret)
+	}
+	if len(auth.exchangeTenants) != 1 || auth.exchangeTenants[0] != "partner-tenant" {
+		t.Errorf("expected exchange for the partner tenant, got: %v", auth.exchangeTenants)
+	}
+
+	// Registries without a tenant keep using the home tenant from the token
+	_, secret, err = helper.Get("myregistry.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if secret != "refresh-fake-azure-token" || auth.exchangeTenants[1] != "fake-tenant-id" {
+		t.Errorf("expected home tenant exchange, got %s / %v", secret, auth.exchangeTenants)
+	}
+}
+
+func TestGetBatch_AcquiresTokenPerTenant(t *testing.T) {
+	auth := &tenantAuthenticator{fakeAuthenticator: *successAuthenticator()}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.Registries = map[string]RegistryConfig{
+		"partnera.azurecr.io": {Tenant: "tenant-a"},
+		"partnerb.azurecr.io": {Tenant: "tenant-a"},
+		"partnerc.azurecr.io": {Tenant: "tenant-c"},
+	}
+
+	result := helper.GetBatch([]string{"partnera.azurecr.io", "partnerb.azurecr.io", "partnerc.azurecr.io"}, 3)
+	if result.Failed() != 0 {
+		t.Fatalf("expected no failures, got: %+v", result.Registries)
+	}
+
+	sort.Strings(auth.requestedTenants)
+	if !reflect.DeepEqual(auth.requestedTenants, []string{"tenant-a", "tenant-c"}) {
+		t.Errorf("expected one token per tenant, got: %v", auth.requestedTenants)
+	}
+}
+
+// discoveringAuthenticator adds tenant discovery to tenantAuthenticator
+type discoveringAuthenticator struct {
+	tenantAuthenticator
+	discovered string
+}
+
+func (d *discoveringAuthenticator) DiscoverTenant(_ string) (string, error) {
+	if d.discovered == "" {
+		return "", fmt.Errorf("not revealed")
+	}
+	return d.discovered, nil
+}
+
+func TestGet_TenantDiscovery_RequestsDiscoveredTenant(t *testing.T) {
+	auth := &discoveringAuthenticator{
+		tenantAuthenticator: tenantAuthenticator{fakeAuthenticator: *successAuthenticator()},
+		discovered:          "owning-tenant",
+	}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.TenantDiscovery = true
+	helper.config.AdditionalTenants = []string{"owning-tenant"}
+
+	_, secret, err := helper.Get("myregistry.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if secret != "refresh-token-for-owning-tenant" {
+		t.Errorf("expected token from the discovered tenant, got: %s", secret)
+	}
+}
+
+func TestGet_TenantDiscovery_HomeTenantUsesHomeToken(t *testing.T) {
+	auth := &discoveringAuthenticator{
+		tenantAuthenticator: tenantAuthenticator{fakeAuthenticator: *successAuthenticator()},
+		discovered:          "fake-tenant-id",
+	}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.TenantDiscovery = true
+
+	if _, secret, err := helper.Get("myregistry.azurecr.io"); err != nil || secret != "refresh-fake-azure-token" {
+		t.Fatalf("expected the home tenant's token, got %q, %v", secret, err)
+	}
+	if len(auth.requestedTenants) != 0 {
+		t.Errorf("expected no tenant-scoped token request, got: %v", auth.requestedTenants)
+	}
+}
+
+func TestGet_TenantDiscovery_RequiresAllowedTenant(t *testing.T) {
+	auth := &discoveringAuthenticator{
+		tenantAuthenticator: tenantAuthenticator{fakeAuthenticator: *successAuthenticator()},
+		discovered:          "owning-tenant",
+	}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.TenantDiscovery = true
+
+	_, _, err := helper.Get("myregistry.azurecr.io")
+	if err == nil || !strings.Contains(err.Error(), "tenant owning-tenant, which is not allowed") {
+		t.Fatalf("expected the discovered tenant to be rejected, got: %v", err)
+	}
+	if len(auth.requestedTenants) != 0 {
+		t.Errorf("expected no tenant-scoped token request, got: %v", auth.requestedTenants)
+	}
+
+	helper.config.AdditionalTenants = []string{"*"}
+	if _, secret, err := helper.Get("myregistry.azurecr.io"); err != nil || secret != "refresh-token-for-owning-tenant" {
+		t.Errorf("expected any tenant to be allowed, got %q, %v", secret, err)
+	}
+}
+
+func TestGet_TenantDiscovery_FallbackForMissingTenant(t *testing.T) {
+	t.Setenv("AZURE_TENANT_ID", "")
+
+	auth := &discoveringAuthenticator{
+		tenantAuthenticator: tenantAuthenticator{fakeAuthenticator: fakeAuthenticator{
+			accessToken: "fake-token",
+			tenantIDErr: fmt.Errorf("tid claim not found"),
+		}},
+		discovered: "owning-tenant",
+	}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.AdditionalTenants = []string{"owning-tenant"}
+
+	_, secret, err := helper.Get("myregistry.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected discovery to resolve the missing tenant, got: %v", err)
+	}
+	if secret != "refresh-token-for-owning-tenant" {
+		t.Errorf("expected token from the discovered tenant, got: %s", secret)
+	}
+
+	auth.discovered = ""
+	if _, _, err := helper.Get("myregistry.azurecr.io"); err == nil || !strings.Contains(err.Error(), "Unable to determine tenant ID") {
+		t.Errorf("expected missing tenant error when discovery fails, got: %v", err)
+	}
+}
+
+func TestGet_ScopeMapTokenSkipsAzure(t *testing.T) {
+	t.Setenv("PARTNER_TOKEN", "partner-password")
+
+	helper := NewACRHelperWithAuthenticator(&fakeAuthenticator{accessTokenErr: fmt.Errorf("must not be called")})
+	helper.config.Registries = map[string]RegistryConfig{
+		"partner.azurecr.io": {Token: &ScopeMapTokenConfig{Name: "partner", Source: SecretSourceEnv, Value: "PARTNER_TOKEN"}},
+	}
+
+	username, secret, err := helper.Get("https://partner.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if username != "partner" || secret != "partner-password" {
+		t.Errorf("expected partner/partner-password, got %s/%s", username, secret)
+	}
+
+	result := helper.GetBatch([]string{"partner.azurecr.io", "other.azurecr.io"}, 2)
+	if result.Registries[0].Secret != "partner-password" || result.Registries[0].Error != "" {
+		t.Errorf("expected scope map token in batch, got: %+v", result.Registries[0])
+	}
+	if !strings.Contains(result.Registries[1].Error, "must not be called") {
+		t.Errorf("expected other registry to use Azure, got: %+v", result.Registries[1])
+	}
+}
+
+// adminAuthenticator adds admin credential retrieval to fakeAuthenticator
+type adminAuthenticator struct {
+	fakeAuthenticator
+	resourceID string
+	tenantID   string
+	password   string
+	calls      int
+	rejected   bool
+}
+
+func (a *adminAuthenticator) GetAdminCredentials(_, resourceID, tenantID string) (string, string, error) {
+	a.resourceID, a.tenantID = resourceID, tenantID
+	a.calls++
+	if a.password != "" {
+		return "legacy", a.password, nil
+	}
+	return "legacy", "admin-secret", nil
+}
+
+func (a *adminAuthenticator) GetACRAccessToken(_, _, _, _ string) (string, error) {
+	if a.rejected {
+		return "", fmt.Errorf("access token request failed with status 401")
+	}
+	return "", fmt.Errorf("unexpected access token request")
+}
+
+func TestGet_AdminCredentials(t *testing.T) {
+	auth := &adminAuthenticator{fakeAuthenticator: fakeAuthenticator{accessTokenErr: fmt.Errorf("must not be called")}}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.Registries = map[string]RegistryConfig{
+		"legacy.azurecr.io": {AdminCredentials: true, ResourceID: "/subscriptions/s/resourceGroups/g/providers/Microsoft.ContainerRegistry/registries/legacy", Tenant: "t"},
+	}
+
+	username, secret, err := helper.Get("legacy.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if username != "legacy" || secret != "admin-secret" {
+		t.Errorf("expected admin credentials, got %s/%s", username, secret)
+	}
+	if !strings.HasSuffix(auth.resourceID, "/registries/legacy") || auth.tenantID != "t" {
+		t.Errorf("expected configured resource ID and tenant, got %q %q", auth.resourceID, auth.tenantID)
+	}
+
+	if _, _, err := NewACRHelperWithAuthenticator(successAuthenticator()).adminCredentials("legacy.azurecr.io", RegistryConfig{}); err == nil {
+		t.Error("expected error for authenticator without admin credential support")
+	}
+}
+
+func TestGet_AdminCredentialsCache(t *testing.T) {
+	auth := &adminAuthenticator{}
+	helper := NewACRHelperWithAuthenticator(auth)
+	helper.config.Registries = map[string]RegistryConfig{"legacy.azurecr.io": {AdminCredentials: true}}
+	helper.cache = NewMemoryCacheStore()
+
+	if _, _, err := helper.Get("legacy.azurecr.io"); err != nil {
+		t.Fatal(err)
+	}
+	auth.password = "regenerated-secret"
+	if _, secret, _ := helper.Get("legacy.azurecr.io"); secret != "admin-secret" || auth.calls != 1 {
+		t.Errorf("expected cached admin credentials, got %q after %d calls", secret, auth.calls)
+	}
+
+	// Credentials the registry rejects are read again
+	auth.rejected = true
+	if _, err := helper.CheckPermission("legacy.azurecr.io", "", ActionPull); err == nil {
+		t.Fatal("expected rejected credentials to fail the check")
+	}
+	if _, secret, _ := helper.Get("legacy.azurecr.io"); secret != "regenerated-secret" || auth.calls != 2 {
+		t.Errorf("expected new admin credentials, got %q after %d calls", secret, auth.calls)
+	}
+}
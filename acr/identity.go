@@ -0,0 +1,98 @@
+package acr
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Kind of credentials built from an assertion source
+const credentialAssertion = "assertion"
+
+// CredentialIdentifier is implemented by authenticators that can tell which
+// identity they authenticate as without acquiring a token. Cached refresh
+// tokens are keyed by it, so signing in as someone else never returns the
+// previous identity's tokens.
+type CredentialIdentifier interface {
+	// CredentialIdentity returns the credential kind followed by the
+	// account, as far as it is known
+	CredentialIdentity() string
+}
+
+// CredentialIdentity names the configured credential and its account: the
+// saved authentication record for interactive sign-ins, the Azure CLI's
+// default account for the default chain
+func (a *AzureAuthenticator) CredentialIdentity() string {
+	envIdentity := os.Getenv("AZURE_CLIENT_ID") + "/" + os.Getenv("AZURE_TENANT_ID")
+
+	switch a.credentialKind {
+	case CredentialDeviceCode, CredentialBrowser:
+		return a.credentialKind + "/" + savedAccount()
+	case credentialAssertion:
+		return credentialAssertion + "/" + envIdentity
+	}
+
+	// The default credential prefers the account signed in with login
+	if account := savedAccount(); account != "" {
+		return "login/" + account
+	}
+	return CredentialDefault + "/" + envIdentity + "/" + azureCLIAccount()
+}
+
+// CredentialIdentity names the plugin command
+func (p *PluginAuthenticator) CredentialIdentity() string {
+	return "plugin/" + p.plugin.command
+}
+
+// savedAccount returns the home account of the saved authentication record,
+// or "" when nobody is signed in
+func savedAccount() string {
+	path, err := DefaultAuthenticationRecordFile()
+	if err != nil {
+		return ""
+	}
+	record, err := LoadAuthenticationRecord(path)
+	if err != nil {
+		return ""
+	}
+	return firstNonEmpty(record.HomeAccountID, record.Username)
+}
+
+// azureCLIAccount returns the user and tenant of the Azure CLI's default
+// subscription, which changes with "az login" and "az account set"
+func azureCLIAccount() string {
+	dir := os.Getenv("AZURE_CONFIG_DIR")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".azure")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "azureProfile.json")) // #nosec G304 -- the Azure CLI's profile
+	if err != nil {
+		return ""
+	}
+
+	var profile struct {
+		Subscriptions []struct {
+			IsDefault bool   `json:"isDefault"`
+			TenantID  string `json:"tenantId"`
+			User      struct {
+				Name string `json:"name"`
+			} `json:"user"`
+		} `json:"subscriptions"`
+	}
+	// The Azure CLI writes the file with a byte order mark
+	if json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &profile) != nil {
+		return ""
+	}
+	for _, subscription := range profile.Subscriptions {
+		if subscription.IsDefault {
+			return subscription.User.Name + "/" + subscription.TenantID
+		}
+	}
+	return ""
+}
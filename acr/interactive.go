@@ -0,0 +1,341 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Credentials the helper can authenticate with
+const (
+	CredentialDefault    = "default"
+	CredentialDeviceCode = "devicecode"
+	CredentialBrowser    = "browser"
+)
+
+// Time a user has to complete an interactive sign-in
+const InteractiveLoginTimeout = 5 * time.Minute
+
+// ErrLoginRequired is returned when the account signed in with the login
+// command has no usable tokens left
+var ErrLoginRequired = errors.New("sign-in expired: run 'docker-credential-acr login' again")
+
+// authenticatingCredential is an interactive azidentity credential
+type authenticatingCredential interface {
+	azcore.TokenCredential
+	Authenticate(ctx context.Context, opts *policy.TokenRequestOptions) (azidentity.AuthenticationRecord, error)
+}
+
+// DefaultAuthenticationRecordFile returns $DOCKER_CREDENTIAL_ACR_AUTH_RECORD,
+// falling back to authentication-record.json next to the config file
+func DefaultAuthenticationRecordFile() (string, error) {
+	if path := os.Getenv(EnvAuthRecord); path != "" {
+		return path, nil
+	}
+
+	configFile, err := DefaultConfigFile()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(configFile), "authentication-record.json"), nil
+}
+
+// LoadAuthenticationRecord reads a saved authentication record; a missing
+// file yields the zero record
+func LoadAuthenticationRecord(path string) (azidentity.AuthenticationRecord, error) {
+	var record azidentity.AuthenticationRecord
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return record, nil
+	}
+	if err != nil {
+		return record, fmt.Errorf("failed to read authentication record: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("invalid authentication record %s: %w", path, err)
+	}
+	return record, nil
+}
+
+// SaveAuthenticationRecord writes an authentication record. It holds no
+// secrets, only the account and tenant used to find cached tokens.
+func SaveAuthenticationRecord(path string, record azidentity.AuthenticationRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, append(data, '\n'), 0o600)
+}
+
+// RemoveAuthenticationRecord deletes a saved authentication record; it
+// reports false when there was none
+func RemoveAuthenticationRecord(path string) (bool, error) {
+	err := os.Remove(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to remove authentication record: %w", err)
+	}
+	return true, nil
+}
+
+// Name of the helper's persistent MSAL token cache. It is kept apart from
+// the default cache of other Azure SDK applications, so logout can remove it.
+// The Azure CLI stores its tokens elsewhere, in a format the Azure SDK cannot
+// read, so "az login" does not sign the helper in.
+const persistentCacheName = "docker-credential-acr"
+
+// RemovePersistentCache deletes the persistent MSAL token cache, including
+// the separate cache of tokens with continuous access evaluation
+func RemovePersistentCache() error {
+	var errs []error
+	for _, name := range []string{persistentCacheName, persistentCacheName + ".cae"} {
+		if err := removePersistentCache(name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove persistent token cache: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// interactiveCredential signs the user in once, saves the authentication
+// record and afterwards acquires tokens silently from the token cache. With a
+// persistent cache, later invocations of the helper reuse the cached refresh
+// token instead of prompting or spawning the Azure CLI.
+type interactiveCredential struct {
+	kind       string
+	recordPath string
+	options    InteractiveOptions
+
+	mu         sync.Mutex
+	credential authenticatingCredential
+	record     azidentity.AuthenticationRecord
+}
+
+// InteractiveOptions configures interactive sign-in
+type InteractiveOptions struct {
+	// TenantID and ClientID default to AZURE_TENANT_ID and AZURE_CLIENT_ID,
+	// then to the "organizations" tenant and the Azure development client
+	TenantID string
+	ClientID string
+
+	// Cache stores tokens; the zero value keeps them in memory
+	Cache azidentity.Cache
+
+	// LoginRequired fails instead of prompting when the user has to sign in
+	// again, for sessions started by the login command
+	LoginRequired bool
+
+	// Transport and AdditionalTenants are passed on to azidentity
+	Transport         policy.Transporter
+	AdditionalTenants []string
+}
+
+func newInteractiveCredential(kind, recordPath string, opts InteractiveOptions) (*interactiveCredential, error) {
+	if kind != CredentialDeviceCode && kind != CredentialBrowser {
+		return nil, fmt.Errorf("unsupported interactive credential %q", kind)
+	}
+
+	record, err := LoadAuthenticationRecord(recordPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &interactiveCredential{kind: kind, recordPath: recordPath, options: opts}
+	if err := c.setRecord(record); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// setRecord (re)creates the azidentity credential for record. The record's
+// tenant and client take precedence, so tokens are found in the cache.
+func (c *interactiveCredential) setRecord(record azidentity.AuthenticationRecord) error {
+	tenantID := firstNonEmpty(record.TenantID, c.options.TenantID, os.Getenv("AZURE_TENANT_ID"))
+	clientID := firstNonEmpty(record.ClientID, c.options.ClientID, os.Getenv("AZURE_CLIENT_ID"))
+	clientOptions := azcore.ClientOptions{Transport: c.options.Transport}
+
+	var credential authenticatingCredential
+	var err error
+	switch c.kind {
+	case CredentialDeviceCode:
+		credential, err = azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			ClientOptions:                  clientOptions,
+			AdditionallyAllowedTenants:     c.options.AdditionalTenants,
+			AuthenticationRecord:           record,
+			Cache:                          c.options.Cache,
+			ClientID:                       clientID,
+			DisableAutomaticAuthentication: true,
+			TenantID:                       tenantID,
+			// stdout carries the credential helper protocol
+			UserPrompt: func(_ context.Context, msg azidentity.DeviceCodeMessage) error {
+				fmt.Fprintln(os.Stderr, msg.Message)
+				return nil
+			},
+		})
+	case CredentialBrowser:
+		credential, err = azidentity.NewInteractiveBrowserCredential(&azidentity.InteractiveBrowserCredentialOptions{
+			ClientOptions:                  clientOptions,
+			AdditionallyAllowedTenants:     c.options.AdditionalTenants,
+			AuthenticationRecord:           record,
+			Cache:                          c.options.Cache,
+			ClientID:                       clientID,
+			DisableAutomaticAuthentication: true,
+			TenantID:                       tenantID,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s credential: %w", c.kind, err)
+	}
+
+	c.credential = credential
+	c.record = record
+	return nil
+}
+
+// Authenticate signs the user in interactively and saves the record
+func (c *interactiveCredential) Authenticate(ctx context.Context, opts *policy.TokenRequestOptions) (azidentity.AuthenticationRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.authenticate(ctx, opts)
+}
+
+func (c *interactiveCredential) authenticate(ctx context.Context, opts *policy.TokenRequestOptions) (azidentity.AuthenticationRecord, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), InteractiveLoginTimeout)
+	defer cancel()
+
+	record, err := c.credential.Authenticate(ctx, opts)
+	if err != nil {
+		return record, err
+	}
+
+	if err := SaveAuthenticationRecord(c.recordPath, record); err != nil {
+		return record, err
+	}
+
+	return record, c.setRecord(record)
+}
+
+// GetToken acquires a token silently, signing the user in first when no
+// usable account is cached
+func (c *interactiveCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.record != (azidentity.AuthenticationRecord{}) {
+		token, err := c.credential.GetToken(ctx, opts)
+		var authRequired *azidentity.AuthenticationRequiredError
+		if !errors.As(err, &authRequired) {
+			return token, err
+		}
+	}
+
+	if c.options.LoginRequired {
+		return azcore.AccessToken{}, ErrLoginRequired
+	}
+
+	if _, err := c.authenticate(ctx, &opts); err != nil {
+		return azcore.AccessToken{}, err
+	}
+
+	// The sign-in may have outlived the caller's deadline
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), TokenRequestTimeout)
+	defer cancel()
+	return c.credential.GetToken(ctx, opts)
+}
+
+// Login signs the user in for later credential requests. Only the transport
+// and tenant settings of cfg are used, so a broken credential configuration
+// does not prevent signing in.
+func Login(cfg *Config, flow, tenantID, clientID string) (azidentity.AuthenticationRecord, error) {
+	a, err := NewAzureAuthenticatorWithTransport(cfg.Transport)
+	if err != nil {
+		return azidentity.AuthenticationRecord{}, err
+	}
+	a.allowedTenants = cfg.AllowedTenants()
+
+	record, err := a.Login(flow, tenantID, clientID)
+	if err != nil {
+		return record, WrapAzureAuthError(err)
+	}
+	return record, nil
+}
+
+// Logout forgets the account signed in with login and removes every token
+// the helper cached for it: the persistent MSAL cache and the refresh token
+// cache. It reports whether an account was signed in; cleanup continues past
+// failures, which are returned together.
+func Logout(cfg *Config) (bool, error) {
+	var errs []error
+
+	removed := false
+	recordPath, err := DefaultAuthenticationRecordFile()
+	if err == nil {
+		removed, err = RemoveAuthenticationRecord(recordPath)
+	}
+	errs = append(errs, err, RemovePersistentCache())
+
+	store, err := NewCacheStore(cfg.Cache)
+	if err == nil && store != nil {
+		err = store.Clear()
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to clear token cache: %w", err))
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// Login signs the user in, storing tokens in the persistent token cache and
+// the account in the default authentication record file
+func (a *AzureAuthenticator) Login(flow, tenantID, clientID string) (azidentity.AuthenticationRecord, error) {
+	if flow != CredentialDeviceCode && flow != CredentialBrowser {
+		return azidentity.AuthenticationRecord{}, fmt.Errorf("unsupported login flow %q: must be devicecode or browser", flow)
+	}
+
+	recordPath, err := DefaultAuthenticationRecordFile()
+	if err != nil {
+		return azidentity.AuthenticationRecord{}, err
+	}
+
+	// Without a persistent cache the sign-in would end with this process
+	tokenCache, err := newPersistentCache()
+	if err != nil {
+		return azidentity.AuthenticationRecord{}, err
+	}
+
+	// Start from an empty record so a previous account is replaced
+	credential := &interactiveCredential{kind: flow, recordPath: recordPath, options: InteractiveOptions{
+		TenantID:          tenantID,
+		ClientID:          clientID,
+		Cache:             tokenCache,
+		Transport:         a.httpClient,
+		AdditionalTenants: a.allowedTenants,
+	}}
+	if err := credential.setRecord(azidentity.AuthenticationRecord{}); err != nil {
+		return azidentity.AuthenticationRecord{}, err
+	}
+
+	return credential.Authenticate(context.Background(), &policy.TokenRequestOptions{Scopes: []string{ACRScope}})
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
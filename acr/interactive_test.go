@@ -0,0 +1,178 @@
+package acr
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+func TestAuthenticationRecord_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "authentication-record.json")
+
+	record, err := LoadAuthenticationRecord(path)
+	if err != nil || record != (azidentity.AuthenticationRecord{}) {
+		t.Fatalf("expected zero record for missing file, got %+v, %v", record, err)
+	}
+
+	want := azidentity.AuthenticationRecord{
+		Authority:     "login.microsoftonline.com",
+		ClientID:      "client",
+		HomeAccountID: "object.tenant",
+		TenantID:      "tenant",
+		Username:      "dev@example.com",
+		Version:       "1.0",
+	}
+	if err := SaveAuthenticationRecord(path, want); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	record, err = LoadAuthenticationRecord(path)
+	if err != nil || record != want {
+		t.Errorf("expected %+v, got %+v, %v", want, record, err)
+	}
+}
+
+func TestNewAzureAuthenticatorFromConfig_InteractiveCredential(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authentication-record.json")
+	t.Setenv(EnvAuthRecord, path)
+
+	record := azidentity.AuthenticationRecord{ClientID: "client", TenantID: "tenant", Username: "dev@example.com", Version: "1.0"}
+	if err := SaveAuthenticationRecord(path, record); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewAzureAuthenticatorFromConfig(&Config{Credential: CredentialDeviceCode})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	cred, err := auth.tokenCredential()
+	if err != nil {
+		t.Skipf("persistent token cache unavailable: %v", err)
+	}
+	interactive, ok := cred.(*interactiveCredential)
+	if !ok {
+		t.Fatalf("expected interactive credential, got %T", cred)
+	}
+	if interactive.record != record {
+		t.Errorf("expected saved record to be used, got %+v", interactive.record)
+	}
+}
+
+func TestNewAzureAuthenticatorFromConfig_InvalidCredential(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"unknown", Config{Credential: "cli"}, "unsupported credential"},
+		{"with assertion", Config{Credential: CredentialBrowser, Assertion: AssertionConfig{Source: AssertionSourceGitHub}}, "cannot be combined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewAzureAuthenticatorFromConfig(&tt.cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestNewAzureAuthenticatorFromConfig_LoginRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authentication-record.json")
+	t.Setenv(EnvAuthRecord, path)
+
+	auth, err := NewAzureAuthenticatorFromConfig(&Config{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := auth.tokenCredential(); err != nil || auth.credential != nil {
+		t.Fatalf("expected default credential chain without login, got %T, %v", auth.credential, err)
+	}
+
+	record := azidentity.AuthenticationRecord{ClientID: "client", TenantID: "tenant", Username: "dev@example.com", Version: "1.0"}
+	if err := SaveAuthenticationRecord(path, record); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err = NewAzureAuthenticatorFromConfig(&Config{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	cred, err := auth.tokenCredential()
+	if err != nil {
+		t.Skipf("persistent token cache unavailable: %v", err)
+	}
+	interactive, ok := cred.(*interactiveCredential)
+	if !ok {
+		t.Fatalf("expected interactive credential, got %T", cred)
+	}
+	if interactive.record != record || !interactive.options.LoginRequired {
+		t.Errorf("expected silent credential for the saved record, got %+v", interactive)
+	}
+}
+
+func TestNewAzureAuthenticatorFromConfig_CorruptLoginRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authentication-record.json")
+	t.Setenv(EnvAuthRecord, path)
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The record is only read when a token is needed
+	auth, err := NewAzureAuthenticatorFromConfig(&Config{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := auth.GetAzureAccessToken(); err == nil || !strings.Contains(err.Error(), "invalid authentication record") {
+		t.Errorf("expected invalid record error, got: %v", err)
+	}
+}
+
+func TestInteractiveCredential_LoginRequired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authentication-record.json")
+
+	credential, err := newInteractiveCredential(CredentialDeviceCode, path, InteractiveOptions{LoginRequired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = credential.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{ACRScope}})
+	if !errors.Is(err, ErrLoginRequired) {
+		t.Errorf("expected ErrLoginRequired, got: %v", err)
+	}
+}
+
+func TestRemoveAuthenticationRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authentication-record.json")
+
+	if removed, err := RemoveAuthenticationRecord(path); removed || err != nil {
+		t.Errorf("expected nothing to remove, got %v, %v", removed, err)
+	}
+
+	if err := SaveAuthenticationRecord(path, azidentity.AuthenticationRecord{Username: "dev@example.com", Version: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := RemoveAuthenticationRecord(path); !removed || err != nil {
+		t.Errorf("expected record to be removed, got %v, %v", removed, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected record file to be gone, got: %v", err)
+	}
+}
+
+func TestAzureAuthenticator_LoginInvalidFlow(t *testing.T) {
+	t.Setenv(EnvAuthRecord, filepath.Join(t.TempDir(), "authentication-record.json"))
+
+	_, err := (&AzureAuthenticator{}).Login("password", "", "")
+	if err == nil || !strings.Contains(err.Error(), "unsupported login flow") {
+		t.Errorf("expected unsupported flow error, got: %v", err)
+	}
+}
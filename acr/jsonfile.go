@@ -0,0 +1,215 @@
+package acr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Default indentation used when writing a new JSON file (matches the Docker CLI)
+const defaultJSONIndent = "\t"
+
+// jsonObjectFile is a JSON object stored on disk. Top-level keys keep their
+// original order and values are kept verbatim, so tools that only touch a few
+// keys do not reformat or drop settings they do not understand.
+type jsonObjectFile struct {
+	path   string
+	indent string
+	order  []string
+	values map[string]json.RawMessage
+}
+
+// loadJSONObjectFile reads a JSON object file; a missing or empty file yields
+// an empty object
+func loadJSONObjectFile(path string) (*jsonObjectFile, error) {
+	f := &jsonObjectFile{
+		path:   path,
+		indent: defaultJSONIndent,
+		values: map[string]json.RawMessage{},
+	}
+
+	data, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return f, nil
+	}
+
+	if err := f.parse(data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	f.indent = detectJSONIndent(data)
+
+	return f, nil
+}
+
+func (f *jsonObjectFile) parse(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("expected a JSON object")
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+
+		if _, seen := f.values[key]; !seen {
+			f.order = append(f.order, key)
+		}
+		f.values[key] = value
+	}
+
+	_, err = dec.Token()
+	return err
+}
+
+// detectJSONIndent returns the indentation of the first indented line
+func detectJSONIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return defaultJSONIndent
+}
+
+// get decodes the value stored under key into v; it reports false when the
+// key does not exist
+func (f *jsonObjectFile) get(key string, v any) (bool, error) {
+	raw, ok := f.values[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("invalid %q in %s: %w", key, f.path, err)
+	}
+	return true, nil
+}
+
+// set stores v under key, appending new keys at the end
+func (f *jsonObjectFile) set(key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.values[key]; !ok {
+		f.order = append(f.order, key)
+	}
+	f.values[key] = raw
+	return nil
+}
+
+// remove deletes key from the object
+func (f *jsonObjectFile) remove(key string) {
+	if _, ok := f.values[key]; !ok {
+		return
+	}
+	delete(f.values, key)
+	for i, k := range f.order {
+		if k == key {
+			f.order = append(f.order[:i], f.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// marshal renders the object using the file's original indentation
+func (f *jsonObjectFile) marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+
+	for i, key := range f.order {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n" + f.indent)
+
+		quoted, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(quoted)
+		buf.WriteString(": ")
+
+		if err := json.Indent(&buf, f.values[key], f.indent, f.indent); err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %w", key, err)
+		}
+	}
+
+	if len(f.order) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+
+	return buf.Bytes(), nil
+}
+
+// save atomically writes the object back to its path with owner-only permissions
+func (f *jsonObjectFile) save() error {
+	data, err := f.marshal()
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(f.path, data, 0o600)
+}
+
+// WriteFileAtomic writes data to a temporary file in the target directory and
+// renames it over path, so readers never observe a partially written file
+func WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on %s: %w", tmpName, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmpName, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpName, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpName, err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package acr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Kubernetes secret type for registry credentials
+const DockerConfigJSONSecretType = "kubernetes.io/dockerconfigjson"
+
+// DNS-1123 subdomain, as required for Kubernetes object names
+var kubeNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// dockerConfigAuth is one entry of a .dockerconfigjson "auths" map
+type dockerConfigAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// PullSecret builds a kubernetes.io/dockerconfigjson Secret for ACR registries
+type PullSecret struct {
+	Name      string
+	Namespace string
+
+	auths     map[string]dockerConfigAuth
+	expiresAt time.Time
+}
+
+// NewPullSecret creates an empty pull secret; namespace may be empty
+func NewPullSecret(name, namespace string) (*PullSecret, error) {
+	if len(name) > 253 || !kubeNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid secret name %q: must be a DNS-1123 subdomain", name)
+	}
+	if namespace != "" && (len(namespace) > 63 || !kubeNameRegex.MatchString(namespace) || strings.Contains(namespace, ".")) {
+		return nil, fmt.Errorf("invalid namespace %q: must be a DNS-1123 label", namespace)
+	}
+
+	return &PullSecret{
+		Name:      name,
+		Namespace: namespace,
+		auths:     map[string]dockerConfigAuth{},
+	}, nil
+}
+
+// AddCredentials adds the credentials for host. The earliest refresh token
+// expiry across all registries becomes the secret's expiry. Registries with
+// anonymous pull (no secret) are pulled without credentials and get no
+// entry; AddCredentials reports whether it added one.
+func (s *PullSecret) AddCredentials(host, username, secret string) bool {
+	if secret == "" {
+		return false
+	}
+
+	s.auths[host] = dockerConfigAuth{
+		Username: username,
+		Password: secret,
+		Auth:     base64.StdEncoding.EncodeToString([]byte(username + ":" + secret)),
+	}
+
+	if expiry, err := TokenExpiry(secret); err == nil {
+		if s.expiresAt.IsZero() || expiry.Before(s.expiresAt) {
+			s.expiresAt = expiry
+		}
+	}
+	return true
+}
+
+// ExpiresAt returns the earliest token expiry, or the zero time if unknown
+func (s *PullSecret) ExpiresAt() time.Time {
+	return s.expiresAt
+}
+
+// RefreshAt returns when the secret should be regenerated: refreshBefore
+// ahead of expiry, but never earlier than minInterval from now. Secrets with
+// unknown expiry are refreshed after fallback.
+func (s *PullSecret) RefreshAt(now time.Time, refreshBefore, minInterval, fallback time.Duration) time.Time {
+	if s.expiresAt.IsZero() {
+		return now.Add(fallback)
+	}
+
+	refreshAt := s.expiresAt.Add(-refreshBefore)
+	if earliest := now.Add(minInterval); refreshAt.Before(earliest) {
+		return earliest
+	}
+	return refreshAt
+}
+
+// dockerConfigJSON returns the base64 encoded .dockerconfigjson payload
+func (s *PullSecret) dockerConfigJSON() (string, error) {
+	data, err := json.Marshal(map[string]any{"auths": s.auths})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// JSON renders the Secret manifest as JSON
+func (s *PullSecret) JSON() ([]byte, error) {
+	payload, err := s.dockerConfigJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := map[string]string{"name": s.Name}
+	if s.Namespace != "" {
+		metadata["namespace"] = s.Namespace
+	}
+
+	data, err := json.MarshalIndent(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   metadata,
+		"type":       DockerConfigJSONSecretType,
+		"data":       map[string]string{".dockerconfigjson": payload},
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// YAML renders the Secret manifest as YAML. All values are either validated
+// names or base64, so no quoting is required.
+func (s *PullSecret) YAML() ([]byte, error) {
+	payload, err := s.dockerConfigJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\n")
+	b.WriteString("kind: Secret\n")
+	b.WriteString("metadata:\n")
+	fmt.Fprintf(&b, "  name: %s\n", s.Name)
+	if s.Namespace != "" {
+		fmt.Fprintf(&b, "  namespace: %s\n", s.Namespace)
+	}
+	fmt.Fprintf(&b, "type: %s\n", DockerConfigJSONSecretType)
+	b.WriteString("data:\n")
+	fmt.Fprintf(&b, "  .dockerconfigjson: %s\n", payload)
+
+	return []byte(b.String()), nil
+}
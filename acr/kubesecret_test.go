@@ -0,0 +1,134 @@
+package acr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPullSecret_JSONManifest(t *testing.T) {
+	secret, err := NewPullSecret("acr-pull", "team-a")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	secret.AddCredentials("myregistry.azurecr.io", "00000000-0000-0000-0000-000000000000", "refresh-token")
+
+	data, err := secret.JSON()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	var manifest struct {
+		Kind     string            `json:"kind"`
+		Type     string            `json:"type"`
+		Metadata map[string]string `json:"metadata"`
+		Data     map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("manifest is not valid JSON: %v", err)
+	}
+	if manifest.Kind != "Secret" || manifest.Type != DockerConfigJSONSecretType {
+		t.Errorf("unexpected kind/type: %s/%s", manifest.Kind, manifest.Type)
+	}
+	if manifest.Metadata["name"] != "acr-pull" || manifest.Metadata["namespace"] != "team-a" {
+		t.Errorf("unexpected metadata: %v", manifest.Metadata)
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(manifest.Data[".dockerconfigjson"])
+	if err != nil {
+		t.Fatalf("payload is not base64: %v", err)
+	}
+	var dockerConfig struct {
+		Auths map[string]dockerConfigAuth `json:"auths"`
+	}
+	if err := json.Unmarshal(payload, &dockerConfig); err != nil {
+		t.Fatalf("payload is not valid JSON: %v", err)
+	}
+	entry := dockerConfig.Auths["myregistry.azurecr.io"]
+	if entry.Password != "refresh-token" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if auth, _ := base64.StdEncoding.DecodeString(entry.Auth); string(auth) != entry.Username+":refresh-token" {
+		t.Errorf("unexpected auth field: %s", auth)
+	}
+}
+
+func TestPullSecret_YAMLManifest(t *testing.T) {
+	secret, err := NewPullSecret("acr-pull", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.AddCredentials("myregistry.azurecr.io", "user", "token")
+
+	data, err := secret.YAML()
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	yaml := string(data)
+
+	for _, want := range []string{"kind: Secret\n", "  name: acr-pull\n", "type: kubernetes.io/dockerconfigjson\n", "  .dockerconfigjson: "} {
+		if !strings.Contains(yaml, want) {
+			t.Errorf("expected %q in manifest:\n%s", want, yaml)
+		}
+	}
+	if strings.Contains(yaml, "namespace:") {
+		t.Errorf("expected namespace to be omitted:\n%s", yaml)
+	}
+}
+
+func TestPullSecret_SkipsAnonymousRegistries(t *testing.T) {
+	secret, err := NewPullSecret("acr-pull", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secret.AddCredentials("private.azurecr.io", "user", "token") {
+		t.Error("expected an entry for the private registry")
+	}
+	if secret.AddCredentials("public.azurecr.io", "", "") {
+		t.Error("expected anonymous registry to be skipped")
+	}
+
+	payload, err := secret.dockerConfigJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(payload)
+	if !strings.Contains(string(data), "private.azurecr.io") || strings.Contains(string(data), "public.azurecr.io") {
+		t.Errorf("expected only the private registry, got: %s", data)
+	}
+}
+
+func TestNewPullSecret_InvalidNames(t *testing.T) {
+	if _, err := NewPullSecret("Bad_Name", ""); err == nil {
+		t.Error("expected error for invalid name")
+	}
+	if _, err := NewPullSecret("ok", "bad.namespace"); err == nil {
+		t.Error("expected error for invalid namespace")
+	}
+}
+
+func TestPullSecret_RefreshAt(t *testing.T) {
+	now := time.Now()
+	secret, err := NewPullSecret("acr-pull", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := secret.RefreshAt(now, 15*time.Minute, time.Minute, time.Hour); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected fallback interval for unknown expiry, got %s", got)
+	}
+
+	secret.AddCredentials("a.azurecr.io", "u", makeToken(t, now.Add(3*time.Hour)))
+	secret.AddCredentials("b.azurecr.io", "u", makeToken(t, now.Add(2*time.Hour)))
+
+	want := secret.ExpiresAt().Add(-15 * time.Minute)
+	if got := secret.RefreshAt(now, 15*time.Minute, time.Minute, time.Hour); !got.Equal(want) {
+		t.Errorf("expected refresh ahead of the earliest expiry %s, got %s", want, got)
+	}
+
+	if got := secret.RefreshAt(now, 5*time.Hour, time.Minute, time.Hour); !got.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected minimum interval, got %s", got)
+	}
+}
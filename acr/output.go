@@ -0,0 +1,108 @@
+package acr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Output formats for the token command
+const (
+	OutputRaw              = "raw"
+	OutputEnv              = "env"
+	OutputAuth             = "auth"
+	OutputDockerAuthConfig = "docker-auth-config"
+	OutputGitHub           = "github"
+	OutputGitLab           = "gitlab"
+)
+
+// Default prefix for environment variable names (ACR_REGISTRY, ACR_USERNAME, ...)
+const DefaultEnvPrefix = "ACR_"
+
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// RegistryCredentials are the credentials obtained for one registry
+type RegistryCredentials struct {
+	Registry string
+	Username string
+	Secret   string
+}
+
+// Auth returns the base64 "username:secret" blob used in Docker auth entries
+func (c RegistryCredentials) Auth() string {
+	return base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Secret))
+}
+
+// EnvVars returns the credentials as ordered NAME=value pairs
+func (c RegistryCredentials) EnvVars(prefix string) ([][2]string, error) {
+	if !envNameRegex.MatchString(prefix + "X") {
+		return nil, fmt.Errorf("invalid environment variable prefix %q", prefix)
+	}
+
+	return [][2]string{
+		{prefix + "REGISTRY", c.Registry},
+		{prefix + "USERNAME", c.Username},
+		{prefix + "PASSWORD", c.Secret},
+		{prefix + "AUTH", c.Auth()},
+	}, nil
+}
+
+// FormatShellExports renders POSIX shell export statements
+func FormatShellExports(c RegistryCredentials, prefix string) (string, error) {
+	vars, err := c.EnvVars(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, v := range vars {
+		fmt.Fprintf(&b, "export %s=%s\n", v[0], shellQuote(v[1]))
+	}
+	return b.String(), nil
+}
+
+// FormatDotenv renders NAME=value lines as used by GitLab dotenv reports and
+// the GitHub Actions $GITHUB_ENV file. Values are never multi-line.
+func FormatDotenv(c RegistryCredentials, prefix string) (string, error) {
+	vars, err := c.EnvVars(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, v := range vars {
+		if strings.ContainsAny(v[1], "\r\n") {
+			return "", fmt.Errorf("value for %s contains a newline", v[0])
+		}
+		fmt.Fprintf(&b, "%s=%s\n", v[0], v[1])
+	}
+	return b.String(), nil
+}
+
+// FormatGitHubMasks renders ::add-mask:: workflow commands for all secret
+// values, so GitHub Actions redacts them from the logs
+func FormatGitHubMasks(c RegistryCredentials) string {
+	return fmt.Sprintf("::add-mask::%s\n::add-mask::%s\n", c.Secret, c.Auth())
+}
+
+// FormatDockerAuthConfig renders a DOCKER_AUTH_CONFIG JSON document for the
+// given registries
+func FormatDockerAuthConfig(creds []RegistryCredentials) (string, error) {
+	auths := map[string]map[string]string{}
+	for _, c := range creds {
+		auths[c.Registry] = map[string]string{"auth": c.Auth()}
+	}
+
+	data, err := json.Marshal(map[string]any{"auths": auths})
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// shellQuote wraps s in single quotes, escaping embedded single quotes
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
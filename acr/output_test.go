@@ -0,0 +1,75 @@
+package acr
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var testCredentials = RegistryCredentials{
+	Registry: "myregistry.azurecr.io",
+	Username: ACRRefreshTokenUsername,
+	Secret:   "tok'en",
+}
+
+func TestFormatShellExports(t *testing.T) {
+	out, err := FormatShellExports(testCredentials, "REG_")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	for _, want := range []string{
+		"export REG_REGISTRY='myregistry.azurecr.io'\n",
+		"export REG_USERNAME='00000000-0000-0000-0000-000000000000'\n",
+		`export REG_PASSWORD='tok'"'"'en'` + "\n",
+		"export REG_AUTH='" + testCredentials.Auth() + "'\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestFormatDotenv(t *testing.T) {
+	out, err := FormatDotenv(testCredentials, DefaultEnvPrefix)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if !strings.HasPrefix(out, "ACR_REGISTRY=myregistry.azurecr.io\nACR_USERNAME=") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	if _, err := FormatDotenv(RegistryCredentials{Secret: "a\nb"}, DefaultEnvPrefix); err == nil {
+		t.Error("expected error for multi-line value")
+	}
+	if _, err := FormatDotenv(testCredentials, "1-bad"); err == nil {
+		t.Error("expected error for invalid prefix")
+	}
+}
+
+func TestFormatGitHubMasks(t *testing.T) {
+	out := FormatGitHubMasks(testCredentials)
+	if !strings.HasPrefix(out, "::add-mask::tok'en\n") || !strings.Contains(out, "::add-mask::"+testCredentials.Auth()) {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestFormatDockerAuthConfig(t *testing.T) {
+	other := RegistryCredentials{Registry: "other.azurecr.io", Username: "u", Secret: "s"}
+	out, err := FormatDockerAuthConfig([]RegistryCredentials{testCredentials, other})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(out), &cfg); err != nil {
+		t.Fatalf("output is not valid JSON: %v", err)
+	}
+	if cfg.Auths["other.azurecr.io"].Auth != "dTpz" || cfg.Auths["myregistry.azurecr.io"].Auth != testCredentials.Auth() {
+		t.Errorf("unexpected auths: %+v", cfg.Auths)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !darwin || cgo
+
+package acr
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity/cache"
+)
+
+// newPersistentCache returns the helper's persistent MSAL token cache
+func newPersistentCache() (azidentity.Cache, error) {
+	c, err := cache.New(&cache.Options{Name: persistentCacheName})
+	if err != nil {
+		return azidentity.Cache{}, fmt.Errorf("persistent token cache unavailable: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,19 @@
+//go:build darwin && cgo
+
+package acr
+
+import (
+	"context"
+
+	"github.com/AzureAD/microsoft-authentication-extensions-for-go/cache/accessor"
+)
+
+// removePersistentCache deletes the keychain item azidentity/cache stores
+// the named cache in
+func removePersistentCache(name string) error {
+	storage, err := accessor.New(name, accessor.WithAccount("MSALCache"))
+	if err != nil {
+		return err
+	}
+	return storage.Delete(context.Background())
+}
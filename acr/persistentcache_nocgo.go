@@ -0,0 +1,20 @@
+//go:build darwin && !cgo
+
+package acr
+
+import (
+	"errors"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// newPersistentCache fails: azidentity/cache needs cgo for the Keychain
+func newPersistentCache() (azidentity.Cache, error) {
+	return azidentity.Cache{}, errors.New("persistent token cache unavailable: this build of the helper has no Keychain support (built without cgo)")
+}
+
+// removePersistentCache has nothing to remove; without cgo the cache is
+// never created
+func removePersistentCache(string) error {
+	return nil
+}
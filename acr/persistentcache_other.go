@@ -0,0 +1,24 @@
+//go:build !darwin
+
+package acr
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// removePersistentCache deletes the file azidentity/cache stores the named
+// cache in: <user cache dir>/.IdentityService/<name>, encrypted with a
+// keyring key on Linux and with DPAPI on Windows
+func removePersistentCache(name string) error {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, ".IdentityService", name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package acr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// Version of the plugin protocol
+const PluginAPIVersion = "v1"
+
+// Kinds of tokens a plugin can return
+const (
+	// PluginTokenAAD is an Azure AD access token, exchanged for an ACR
+	// refresh token by the helper
+	PluginTokenAAD = "aad"
+
+	// PluginTokenACR is an ACR refresh token returned to Docker as is
+	PluginTokenACR = "acr"
+)
+
+const (
+	// Plugin output beyond this size is rejected
+	maxPluginOutput = 1 << 20
+
+	// Time the plugin's output pipes may stay open after it was killed
+	pluginWaitDelay = time.Second
+)
+
+// PluginConfig configures an external command issuing tokens in place of the
+// Azure Identity SDK, e.g. to integrate a bespoke token broker
+type PluginConfig struct {
+	// Command is run with "sh -c"; an empty command disables the plugin
+	Command string `json:"command,omitempty"`
+
+	// Timeout is the time the command may take, as a Go duration
+	// (default: 30s)
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// PluginRequest is written to the plugin's stdin as JSON
+type PluginRequest struct {
+	APIVersion string `json:"apiVersion"`
+
+	// Registry the token is for; empty for requests not tied to a registry
+	// (e.g. Azure Resource Manager tokens for the discover command)
+	Registry string `json:"registry,omitempty"`
+
+	// Tenant the token should be issued by; empty selects the home tenant
+	Tenant string `json:"tenant,omitempty"`
+
+	// Scope of the requested Azure AD token
+	Scope string `json:"scope"`
+}
+
+// PluginResponse is read from the plugin's stdout as JSON. Unknown fields are
+// rejected so that typos do not go unnoticed.
+type PluginResponse struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Token      string    `json:"token"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+
+	// Tenant that issued an Azure AD token; optional, the token's 'tid'
+	// claim is used otherwise
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// validate checks a response against the protocol
+func (r *PluginResponse) validate() error {
+	if r.APIVersion != PluginAPIVersion {
+		return fmt.Errorf("unsupported apiVersion %q: expected %q", r.APIVersion, PluginAPIVersion)
+	}
+	if r.Kind != PluginTokenAAD && r.Kind != PluginTokenACR {
+		return fmt.Errorf("unsupported kind %q: must be %s or %s", r.Kind, PluginTokenAAD, PluginTokenACR)
+	}
+	if r.Token == "" || strings.ContainsAny(r.Token, " \t\r\n") {
+		return fmt.Errorf("token must be a non-empty string without whitespace")
+	}
+	if r.ExpiresAt.IsZero() {
+		return fmt.Errorf("expiresAt is required")
+	}
+	if !r.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("token expired at %s", r.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ExecPlugin obtains tokens from an external command. It implements
+// azcore.TokenCredential for Azure AD tokens not tied to a registry.
+type ExecPlugin struct {
+	command string
+	timeout time.Duration
+}
+
+// NewExecPlugin validates the plugin configuration
+func NewExecPlugin(cfg PluginConfig) (*ExecPlugin, error) {
+	if strings.TrimSpace(cfg.Command) == "" {
+		return nil, fmt.Errorf("plugin command is empty")
+	}
+
+	timeout := TokenRequestTimeout
+	if cfg.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid plugin timeout %q: expected a positive duration such as 30s", cfg.Timeout)
+		}
+	}
+
+	return &ExecPlugin{command: cfg.Command, timeout: timeout}, nil
+}
+
+// Token runs the plugin for a request and returns its validated response
+func (p *ExecPlugin) Token(ctx context.Context, req PluginRequest) (*PluginResponse, error) {
+	req.APIVersion = PluginAPIVersion
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := CommandFor(ctx, p.command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = pluginWaitDelay
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin failed: %w", err)
+	}
+
+	// Only buffer up to the limit; the rest is drained so the plugin can exit
+	output, _ := io.ReadAll(io.LimitReader(stdout, maxPluginOutput+1))
+	_, _ = io.Copy(io.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("plugin timed out after %s", p.timeout)
+		}
+		return nil, fmt.Errorf("plugin failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if len(output) > maxPluginOutput {
+		return nil, fmt.Errorf("plugin output exceeds %d bytes", maxPluginOutput)
+	}
+
+	var resp PluginResponse
+	dec := json.NewDecoder(bytes.NewReader(output))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&resp); err != nil {
+		return nil, fmt.Errorf("invalid plugin response: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid plugin response: unexpected data after the JSON object")
+	}
+	if err := resp.validate(); err != nil {
+		return nil, fmt.Errorf("invalid plugin response: %w", err)
+	}
+
+	return &resp, nil
+}
+
+// GetToken requests an Azure AD token for the scopes from the plugin
+func (p *ExecPlugin) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	resp, err := p.Token(ctx, PluginRequest{Tenant: opts.TenantID, Scope: strings.Join(opts.Scopes, " ")})
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	if resp.Kind != PluginTokenAAD {
+		return azcore.AccessToken{}, fmt.Errorf("plugin returned a %q token where an Azure AD token is required", resp.Kind)
+	}
+	return azcore.AccessToken{Token: resp.Token, ExpiresOn: resp.ExpiresAt}, nil
+}
+
+// RegistryTokenProvider is implemented by authenticators that obtain tokens
+// per registry
+type RegistryTokenProvider interface {
+	// GetRegistryToken returns an Azure AD token to exchange or an ACR
+	// refresh token for the registry
+	GetRegistryToken(registryHost, tenantID string) (*PluginResponse, error)
+}
+
+// PluginAuthenticator obtains tokens from an exec plugin. Exchanges and all
+// other registry and ARM requests are made like by AzureAuthenticator, whose
+// credential is the plugin.
+type PluginAuthenticator struct {
+	*AzureAuthenticator
+	plugin *ExecPlugin
+}
+
+// GetRegistryToken asks the plugin for a token for the registry
+func (p *PluginAuthenticator) GetRegistryToken(registryHost, tenantID string) (*PluginResponse, error) {
+	return p.plugin.Token(context.Background(), PluginRequest{Registry: registryHost, Tenant: tenantID, Scope: ACRScope})
+}
+
+// pluginRefreshToken obtains credentials for the registry from a registry
+// token provider, exchanging Azure AD tokens it returns. The registry's
+// tenant is resolved like for other authenticators.
+func (h *ACRHelper) pluginRefreshToken(provider RegistryTokenProvider, registryHost string) (string, string, time.Time, error) {
+	var refreshToken string
+	var expiresAt time.Time
+	azureToken, tenantID, err := h.acquireRegistryToken(registryHost, func(registryTenant string) (string, string, error) {
+		resp, err := provider.GetRegistryToken(registryHost, registryTenant)
+		if err != nil {
+			return "", "", WrapAzureAuthError(err)
+		}
+		if resp.Kind == PluginTokenACR {
+			refreshToken, expiresAt = resp.Token, resp.ExpiresAt
+			return "", "", nil
+		}
+
+		if tenantID := firstNonEmpty(registryTenant, resp.Tenant); tenantID != "" {
+			return resp.Token, tenantID, nil
+		}
+		tenantID, err := h.homeTenant(resp.Token)
+		return resp.Token, tenantID, err
+	})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	if refreshToken != "" {
+		return ACRRefreshTokenUsername, refreshToken, expiresAt, nil
+	}
+
+	username, secret, err := h.exchange(registryHost, tenantID, azureToken)
+	return username, secret, time.Time{}, err
+}
@@ -0,0 +1,302 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// pluginScript writes a plugin that saves its request and prints output
+func pluginScript(t *testing.T, output string) (command, requestFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	requestFile = filepath.Join(dir, "request.json")
+	outputFile := filepath.Join(dir, "output.json")
+	if err := os.WriteFile(outputFile, []byte(output), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return "cat > '" + requestFile + "'; cat '" + outputFile + "'", requestFile
+}
+
+func pluginOutput(kind, token string, expiresAt time.Time) string {
+	return `{"apiVersion": "v1", "kind": "` + kind + `", "token": "` + token + `", "expiresAt": "` + expiresAt.Format(time.RFC3339) + `"}`
+}
+
+func TestExecPlugin_Token(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	command, requestFile := pluginScript(t, pluginOutput(PluginTokenACR, "acr-refresh-token", expiresAt))
+
+	plugin, err := NewExecPlugin(PluginConfig{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := plugin.Token(context.Background(), PluginRequest{Registry: "myregistry.azurecr.io", Tenant: "tenant", Scope: ACRScope})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if resp.Kind != PluginTokenACR || resp.Token != "acr-refresh-token" || !resp.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	data, err := os.ReadFile(requestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req map[string]string
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("request is not valid JSON: %v: %s", err, data)
+	}
+	want := map[string]string{"apiVersion": "v1", "registry": "myregistry.azurecr.io", "tenant": "tenant", "scope": ACRScope}
+	for key, value := range want {
+		if req[key] != value {
+			t.Errorf("expected request %s=%q, got %q", key, value, req[key])
+		}
+	}
+}
+
+func TestExecPlugin_InvalidResponses(t *testing.T) {
+	valid := time.Now().Add(time.Hour).Format(time.RFC3339)
+	expired := time.Now().Add(-time.Minute).Format(time.RFC3339)
+
+	tests := []struct {
+		name    string
+		command string
+		want    string
+	}{
+		{"not JSON", "echo token", "invalid plugin response"},
+		{"wrong apiVersion", `echo '{"apiVersion": "v2", "kind": "aad", "token": "t", "expiresAt": "` + valid + `"}'`, "unsupported apiVersion"},
+		{"unknown field", `echo '{"apiVersion": "v1", "kind": "aad", "token": "t", "expiresAt": "` + valid + `", "expires_in": 3600}'`, "unknown field"},
+		{"unknown kind", `echo '{"apiVersion": "v1", "kind": "basic", "token": "t", "expiresAt": "` + valid + `"}'`, "unsupported kind"},
+		{"empty token", `echo '{"apiVersion": "v1", "kind": "aad", "token": "", "expiresAt": "` + valid + `"}'`, "non-empty"},
+		{"missing expiry", `echo '{"apiVersion": "v1", "kind": "aad", "token": "t"}'`, "expiresAt is required"},
+		{"expired", `echo '{"apiVersion": "v1", "kind": "aad", "token": "t", "expiresAt": "` + expired + `"}'`, "expired"},
+		{"trailing data", `echo '{"apiVersion": "v1", "kind": "aad", "token": "t", "expiresAt": "` + valid + `"} {}'`, "unexpected data"},
+		{"exit status", "echo 'broker unavailable' >&2; exit 3", "broker unavailable"},
+		{"oversized output", "head -c 2000000 /dev/zero", "output exceeds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin, err := NewExecPlugin(PluginConfig{Command: tt.command})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = plugin.Token(context.Background(), PluginRequest{Scope: ACRScope})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestExecPlugin_Timeout(t *testing.T) {
+	plugin, err := NewExecPlugin(PluginConfig{Command: "sleep 10", Timeout: "100ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = plugin.Token(context.Background(), PluginRequest{Scope: ACRScope})
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("expected timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the plugin to be stopped, took %v", elapsed)
+	}
+}
+
+func TestNewExecPlugin_InvalidConfig(t *testing.T) {
+	for _, cfg := range []PluginConfig{{Command: " "}, {Command: "broker", Timeout: "soon"}, {Command: "broker", Timeout: "-1s"}} {
+		if _, err := NewExecPlugin(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestExecPlugin_GetTokenRequiresAzureADToken(t *testing.T) {
+	command, requestFile := pluginScript(t, pluginOutput(PluginTokenACR, "acr-refresh-token", time.Now().Add(time.Hour)))
+	plugin, _ := NewExecPlugin(PluginConfig{Command: command})
+
+	_, err := plugin.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{ManagementScope}})
+	if err == nil || !strings.Contains(err.Error(), "Azure AD token is required") {
+		t.Errorf("expected kind error, got: %v", err)
+	}
+
+	data, _ := os.ReadFile(requestFile)
+	if strings.Contains(string(data), "registry") || !strings.Contains(string(data), ManagementScope) {
+		t.Errorf("expected a registry-less request for the management scope, got: %s", data)
+	}
+}
+
+// pluginFakeAuthenticator returns a fixed plugin response and records the
+// tenant used for the exchange
+type pluginFakeAuthenticator struct {
+	fakeAuthenticator
+	resp           *PluginResponse
+	requestTenant  string
+	exchangeTenant string
+	discovered     string
+}
+
+func (p *pluginFakeAuthenticator) DiscoverTenant(_ string) (string, error) {
+	if p.discovered == "" {
+		return "", fmt.Errorf("not revealed")
+	}
+	return p.discovered, nil
+}
+
+func (p *pluginFakeAuthenticator) GetRegistryToken(_, tenantID string) (*PluginResponse, error) {
+	p.requestTenant = tenantID
+	return p.resp, nil
+}
+
+func (p *pluginFakeAuthenticator) ExchangeForACRToken(_, tenantID, _ string) (string, error) {
+	p.exchangeTenant = tenantID
+	return p.refreshToken, p.refreshTokenErr
+}
+
+func TestGet_PluginTokens(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+
+	t.Run("ACR token is returned as is", func(t *testing.T) {
+		auth := &pluginFakeAuthenticator{
+			fakeAuthenticator: fakeAuthenticator{refreshToken: "must-not-exchange"},
+			resp:              &PluginResponse{Kind: PluginTokenACR, Token: "plugin-refresh-token", ExpiresAt: expiresAt},
+		}
+		helper := NewACRHelperWithAuthenticator(auth)
+
+		username, secret, err := helper.Get("myregistry.azurecr.io")
+		if err != nil || username != ACRRefreshTokenUsername || secret != "plugin-refresh-token" {
+			t.Errorf("expected plugin refresh token, got %s/%s, %v", username, secret, err)
+		}
+		if auth.exchangeTenant != "" {
+			t.Error("expected no exchange")
+		}
+	})
+
+	t.Run("Azure AD token is exchanged", func(t *testing.T) {
+		auth := &pluginFakeAuthenticator{
+			fakeAuthenticator: fakeAuthenticator{tenantID: "token-tenant", refreshToken: "exchanged-refresh-token"},
+			resp:              &PluginResponse{Kind: PluginTokenAAD, Token: "aad-token", ExpiresAt: expiresAt, Tenant: "plugin-tenant"},
+		}
+		helper := NewACRHelperWithAuthenticator(auth)
+
+		_, secret, err := helper.Get("myregistry.azurecr.io")
+		if err != nil || secret != "exchanged-refresh-token" {
+			t.Fatalf("expected exchanged refresh token, got %q, %v", secret, err)
+		}
+		if auth.exchangeTenant != "plugin-tenant" {
+			t.Errorf("expected the plugin's tenant to be used, got %q", auth.exchangeTenant)
+		}
+	})
+
+	t.Run("configured tenant is requested", func(t *testing.T) {
+		auth := &pluginFakeAuthenticator{
+			fakeAuthenticator: fakeAuthenticator{tenantID: "token-tenant", refreshToken: "exchanged-refresh-token"},
+			resp:              &PluginResponse{Kind: PluginTokenAAD, Token: "aad-token", ExpiresAt: expiresAt},
+		}
+		helper := NewACRHelperWithAuthenticator(auth)
+		helper.config = &Config{Registries: map[string]RegistryConfig{"partner.azurecr.io": {Tenant: "partner-tenant"}}}
+
+		if _, _, err := helper.Get("partner.azurecr.io"); err != nil {
+			t.Fatal(err)
+		}
+		if auth.requestTenant != "partner-tenant" || auth.exchangeTenant != "partner-tenant" {
+			t.Errorf("expected partner tenant, requested %q, exchanged %q", auth.requestTenant, auth.exchangeTenant)
+		}
+
+		// Without a configured or returned tenant the token's claim is used
+		if _, _, err := helper.Get("myregistry.azurecr.io"); err != nil {
+			t.Fatal(err)
+		}
+		if auth.exchangeTenant != "token-tenant" {
+			t.Errorf("expected tenant from the token, got %q", auth.exchangeTenant)
+		}
+	})
+
+	t.Run("missing tenant is discovered", func(t *testing.T) {
+		t.Setenv("AZURE_TENANT_ID", "")
+		auth := &pluginFakeAuthenticator{
+			fakeAuthenticator: fakeAuthenticator{tenantIDErr: fmt.Errorf("tid claim not found"), refreshToken: "exchanged-refresh-token"},
+			resp:              &PluginResponse{Kind: PluginTokenAAD, Token: "aad-token", ExpiresAt: expiresAt},
+			discovered:        "owning-tenant",
+		}
+		helper := NewACRHelperWithAuthenticator(auth)
+		helper.config.AdditionalTenants = []string{"owning-tenant"}
+
+		if _, _, err := helper.Get("myregistry.azurecr.io"); err != nil {
+			t.Fatalf("expected discovery to resolve the missing tenant, got: %v", err)
+		}
+		if auth.requestTenant != "owning-tenant" || auth.exchangeTenant != "owning-tenant" {
+			t.Errorf("expected the discovered tenant, requested %q, exchanged %q", auth.requestTenant, auth.exchangeTenant)
+		}
+	})
+}
+
+func TestGet_PluginTokenCaching(t *testing.T) {
+	t.Run("opaque ACR token is cached until the plugin's expiry", func(t *testing.T) {
+		auth := &pluginFakeAuthenticator{
+			resp: &PluginResponse{Kind: PluginTokenACR, Token: "opaque-plugin-token", ExpiresAt: time.Now().Add(time.Hour)},
+		}
+		helper := NewACRHelperWithAuthenticator(auth)
+		helper.cache = NewMemoryCacheStore()
+
+		if _, _, err := helper.Get("myregistry.azurecr.io"); err != nil {
+			t.Fatal(err)
+		}
+		auth.resp = &PluginResponse{Kind: PluginTokenACR, Token: "new-plugin-token", ExpiresAt: time.Now().Add(time.Hour)}
+
+		_, secret, err := helper.Get("myregistry.azurecr.io")
+		if err != nil || secret != "opaque-plugin-token" {
+			t.Errorf("expected the cached plugin token, got %q, %v", secret, err)
+		}
+		if _, secret, _ := helper.GetValidFor("myregistry.azurecr.io", 2*time.Hour); secret != "new-plugin-token" {
+			t.Errorf("expected a new token past the plugin's expiry, got %q", secret)
+		}
+	})
+
+	t.Run("plugin expiry caps the JWT expiry", func(t *testing.T) {
+		auth := &pluginFakeAuthenticator{
+			resp: &PluginResponse{Kind: PluginTokenACR, Token: makeToken(t, time.Now().Add(3*time.Hour)), ExpiresAt: time.Now().Add(20 * time.Minute)},
+		}
+		helper := NewACRHelperWithAuthenticator(auth)
+		helper.cache = NewMemoryCacheStore()
+
+		if _, _, err := helper.Get("myregistry.azurecr.io"); err != nil {
+			t.Fatal(err)
+		}
+		auth.resp = &PluginResponse{Kind: PluginTokenACR, Token: "new-plugin-token", ExpiresAt: time.Now().Add(time.Hour)}
+
+		if _, secret, _ := helper.GetValidFor("myregistry.azurecr.io", 30*time.Minute); secret != "new-plugin-token" {
+			t.Errorf("expected the token to be cached only until the plugin's expiry, got %q", secret)
+		}
+	})
+}
+
+func TestNewACRHelperWithConfig_Plugin(t *testing.T) {
+	t.Setenv(EnvAuthRecord, filepath.Join(t.TempDir(), "authentication-record.json"))
+
+	helper, err := NewACRHelperWithConfig(&Config{Plugin: PluginConfig{Command: "broker"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin, ok := helper.authenticator.(*PluginAuthenticator)
+	if !ok {
+		t.Fatalf("expected plugin authenticator, got %T", helper.authenticator)
+	}
+	if plugin.credential != plugin.plugin {
+		t.Error("expected Azure AD tokens to be requested from the plugin")
+	}
+
+	if _, err := NewACRHelperWithConfig(&Config{Plugin: PluginConfig{Command: "broker"}, Credential: CredentialBrowser}); err == nil {
+		t.Error("expected plugin and interactive credential to be rejected")
+	}
+}
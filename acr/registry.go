@@ -2,9 +2,9 @@ package acr
 
 import (
 	"fmt"
-	"net/url"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
 // ACR registry URL patterns
@@ -14,11 +14,22 @@ const (
 
 	// ACR registry name pattern (alphanumeric, 5-50 chars)
 	ACRRegistryNamePattern = `^[a-z0-9]{5,50}$`
+
+	// Repository path with optional tag and digest following the registry
+	// in an image reference (distribution reference grammar)
+	imageReferencePathPattern = `^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+		`(?::[\w][\w.-]{0,127})?(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`
+
+	// Maximum length of an image name (registry and repository)
+	maxImageNameLength = 255
 )
 
 // RegistryValidator validates and extracts information from ACR registry URLs
 type RegistryValidator struct {
 	registryNameRegex *regexp.Regexp
+
+	// imageReferenceRegex is only set in lenient mode
+	imageReferenceRegex *regexp.Regexp
 }
 
 // NewRegistryValidator creates a new registry validator
@@ -28,15 +39,38 @@ func NewRegistryValidator() *RegistryValidator {
 	}
 }
 
+// NewLenientRegistryValidator creates a registry validator that also extracts
+// the registry from image references such as myregistry.azurecr.io/app:1.2
+// and from registry API URLs such as https://myregistry.azurecr.io/v2/.
+// Ports and user info are rejected as in strict mode.
+func NewLenientRegistryValidator() *RegistryValidator {
+	v := NewRegistryValidator()
+	v.imageReferenceRegex = regexp.MustCompile(imageReferencePathPattern)
+	return v
+}
+
 // ParseAndNormalize validates serverURL and returns normalized host + registry name.
 // Normalized host format: <registry-name>.azurecr.io
 func (v *RegistryValidator) ParseAndNormalize(serverURL string) (string, string, error) {
-	raw := strings.TrimSpace(strings.ToLower(serverURL))
+	raw := strings.TrimSpace(serverURL)
 	if raw == "" {
 		return "", "", fmt.Errorf("registry URL is empty")
 	}
 
-	host, err := normalizeRegistryHost(raw)
+	// Checked before lowercasing, which maps some non-ASCII letters such as
+	// the Kelvin sign to ASCII ones
+	if !isASCII(raw) {
+		return "", "", fmt.Errorf("invalid registry URL: internationalized host names are not supported, ACR registry names are ASCII")
+	}
+
+	if v.imageReferenceRegex != nil {
+		var err error
+		if raw, err = v.registryFromReference(raw); err != nil {
+			return "", "", err
+		}
+	}
+
+	host, err := normalizeRegistryHost(strings.ToLower(raw))
 	if err != nil {
 		return "", "", err
 	}
@@ -60,46 +94,79 @@ func (v *RegistryValidator) ParseAndNormalize(serverURL string) (string, string,
 	return host, registryName, nil
 }
 
+// normalizeRegistryHost extracts the host from "host" or "scheme://host".
+// Both forms go through the same checks; the host is not URL-decoded, so
+// percent-encoded names are rejected instead of being decoded.
 func normalizeRegistryHost(raw string) (string, error) {
-	if strings.Contains(raw, "://") {
-		parsed, err := url.Parse(raw)
-		if err != nil {
-			return "", fmt.Errorf("invalid registry URL: %w", err)
+	host := raw
+	if scheme, rest, ok := strings.Cut(raw, "://"); ok {
+		if !strings.EqualFold(scheme, "https") && !strings.EqualFold(scheme, "http") {
+			return "", fmt.Errorf("invalid registry URL: unsupported scheme %q", scheme)
 		}
+		host = rest
+	}
 
-		if parsed.Host == "" {
-			return "", fmt.Errorf("invalid registry URL: missing host")
-		}
+	host = strings.TrimSuffix(host, "/")
+	if host == "" {
+		return "", fmt.Errorf("invalid registry URL: missing host")
+	}
 
-		if parsed.Port() != "" {
-			return "", fmt.Errorf("invalid registry URL: ports are not allowed")
-		}
+	if strings.ContainsAny(host, "/?#") {
+		return "", fmt.Errorf("invalid registry URL: paths, query, and fragment are not allowed")
+	}
 
-		if parsed.Path != "" && parsed.Path != "/" {
-			return "", fmt.Errorf("invalid registry URL: paths are not allowed")
-		}
+	if strings.Contains(host, "@") {
+		return "", fmt.Errorf("invalid registry URL: user info is not allowed")
+	}
 
-		if parsed.RawQuery != "" || parsed.Fragment != "" || parsed.User != nil {
-			return "", fmt.Errorf("invalid registry URL: query, fragment, and user info are not allowed")
-		}
+	if strings.Contains(host, ":") {
+		return "", fmt.Errorf("invalid registry URL: ports are not allowed")
+	}
+
+	return strings.TrimSuffix(host, "."), nil
+}
 
-		return strings.TrimSuffix(parsed.Hostname(), "."), nil
+// registryFromReference strips the repository path of an image reference or
+// the path of a /v2/ URL, keeping the scheme and host for normalization
+func (v *RegistryValidator) registryFromReference(raw string) (string, error) {
+	scheme, rest, hasScheme := strings.Cut(raw, "://")
+	if !hasScheme {
+		scheme, rest = "", raw
 	}
 
-	trimmed := strings.TrimSuffix(raw, "/")
-	if strings.ContainsAny(trimmed, "/?#") {
-		return "", fmt.Errorf("invalid registry URL: paths, query, and fragment are not allowed")
+	host, path, hasPath := strings.Cut(rest, "/")
+	if !hasPath || path == "" {
+		return raw, nil
 	}
 
-	if strings.Contains(trimmed, ":") {
-		return "", fmt.Errorf("invalid registry URL: ports are not allowed")
+	switch {
+	case path == "v2" || strings.HasPrefix(path, "v2/"):
+		// Registry API URL; query and fragment belong to the path
+	case hasScheme:
+		return "", fmt.Errorf("invalid registry URL: only /v2/ paths are allowed in URLs")
+	case !v.imageReferenceRegex.MatchString(path):
+		return "", fmt.Errorf("invalid image reference: %s", raw)
+	default:
+		name, _, _ := strings.Cut(path, ":")
+		name, _, _ = strings.Cut(name, "@")
+		if len(host)+1+len(name) > maxImageNameLength {
+			return "", fmt.Errorf("invalid image reference: name exceeds %d characters", maxImageNameLength)
+		}
 	}
 
-	if strings.Contains(trimmed, "@") {
-		return "", fmt.Errorf("invalid registry URL: user info is not allowed")
+	if hasScheme {
+		return scheme + "://" + host, nil
 	}
+	return host, nil
+}
 
-	return strings.TrimSuffix(trimmed, "."), nil
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
 }
 
 // IsACRRegistry checks if a URL is an ACR registry without full validation.
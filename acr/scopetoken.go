@@ -0,0 +1,145 @@
+package acr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+)
+
+// Secret sources for repository-scoped (scope map) token passwords
+const (
+	SecretSourceFile    = "file"
+	SecretSourceEnv     = "env"
+	SecretSourceCommand = "command"
+	SecretSourceStore   = "store"
+)
+
+// ScopeMapTokenConfig configures a registry to use an ACR repository-scoped
+// token (token name and password) instead of the Azure AD exchange
+type ScopeMapTokenConfig struct {
+	// Name of the ACR token, used as username. Optional for the store
+	// source, which returns the username stored with the password.
+	Name string `json:"name,omitempty"`
+
+	// Source is one of file, env, command or store
+	Source string `json:"source"`
+
+	// Value is the file path, environment variable name, shell command or
+	// Docker credential store name (e.g. "pass", "secretservice",
+	// "osxkeychain"), depending on Source
+	Value string `json:"value"`
+}
+
+// validate checks the configuration without reading the secret
+func (c *ScopeMapTokenConfig) validate() error {
+	switch c.Source {
+	case SecretSourceFile, SecretSourceEnv, SecretSourceCommand:
+		if c.Name == "" {
+			return fmt.Errorf("token source %q requires a token name", c.Source)
+		}
+	case SecretSourceStore:
+		if c.Value == HelperName {
+			return fmt.Errorf("token source %q cannot use the %s helper itself", c.Source, HelperName)
+		}
+	default:
+		return fmt.Errorf("unsupported token source %q: must be file, env, command or store", c.Source)
+	}
+
+	if c.Value == "" {
+		return fmt.Errorf("token source %q requires a value", c.Source)
+	}
+
+	return nil
+}
+
+// Credentials reads the token name and password for a registry
+func (c *ScopeMapTokenConfig) Credentials(ctx context.Context, registryHost string) (string, string, error) {
+	if err := c.validate(); err != nil {
+		return "", "", err
+	}
+
+	var password string
+	var err error
+
+	switch c.Source {
+	case SecretSourceFile:
+		password, err = readSecretFile(c.Value)
+	case SecretSourceEnv:
+		password, err = nonEmptySecret(os.Getenv(c.Value), "environment variable "+c.Value)
+	case SecretSourceCommand:
+		password, err = runSecretCommand(ctx, c.Value)
+	case SecretSourceStore:
+		return c.storeCredentials(registryHost)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return c.Name, password, nil
+}
+
+// storeCredentials reads the token from a Docker credential store
+// (docker-credential-<Value>) under the registry host
+func (c *ScopeMapTokenConfig) storeCredentials(registryHost string) (string, string, error) {
+	program := client.NewShellProgramFunc("docker-credential-" + c.Value)
+
+	creds, err := client.Get(program, registryHost)
+	if credentials.IsErrCredentialsNotFound(err) {
+		return "", "", fmt.Errorf("no token for %s in credential store %q", registryHost, c.Value)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("credential store %q: %w", c.Value, err)
+	}
+
+	username := creds.Username
+	if c.Name != "" {
+		username = c.Name
+	}
+	if username == "" {
+		return "", "", fmt.Errorf("credential store %q returned no token name for %s", c.Value, registryHost)
+	}
+
+	password, err := nonEmptySecret(creds.Secret, "credential store "+c.Value)
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, password, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	return nonEmptySecret(string(data), "token file "+path)
+}
+
+func runSecretCommand(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, TokenRequestTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := CommandFor(ctx, command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("token command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nonEmptySecret(stdout.String(), "token command output")
+}
+
+func nonEmptySecret(raw, origin string) (string, error) {
+	secret := strings.TrimSpace(raw)
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", origin)
+	}
+	return secret, nil
+}
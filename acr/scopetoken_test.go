@@ -0,0 +1,98 @@
+package acr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScopeMapTokenConfig_Credentials(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("file-password\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PARTNER_TOKEN", "env-password")
+
+	tests := []struct {
+		name   string
+		config ScopeMapTokenConfig
+		want   string
+	}{
+		{"file", ScopeMapTokenConfig{Name: "partner", Source: SecretSourceFile, Value: tokenFile}, "file-password"},
+		{"env", ScopeMapTokenConfig{Name: "partner", Source: SecretSourceEnv, Value: "PARTNER_TOKEN"}, "env-password"},
+		{"command", ScopeMapTokenConfig{Name: "partner", Source: SecretSourceCommand, Value: "echo command-password"}, "command-password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, password, err := tt.config.Credentials(context.Background(), "myregistry.azurecr.io")
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if username != "partner" || password != tt.want {
+				t.Errorf("expected partner/%s, got %s/%s", tt.want, username, password)
+			}
+		})
+	}
+}
+
+func TestScopeMapTokenConfig_Store(t *testing.T) {
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read server
+if [ "$1" != "get" ] || [ "$server" != "myregistry.azurecr.io" ]; then
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+echo '{"ServerURL":"myregistry.azurecr.io","Username":"stored-token","Secret":"store-password"}'
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := ScopeMapTokenConfig{Source: SecretSourceStore, Value: "fake"}
+
+	username, password, err := config.Credentials(context.Background(), "myregistry.azurecr.io")
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if username != "stored-token" || password != "store-password" {
+		t.Errorf("expected stored-token/store-password, got %s/%s", username, password)
+	}
+
+	config.Name = "override"
+	if username, _, _ := config.Credentials(context.Background(), "myregistry.azurecr.io"); username != "override" {
+		t.Errorf("expected configured name to override stored username, got %s", username)
+	}
+
+	_, _, err = config.Credentials(context.Background(), "other.azurecr.io")
+	if err == nil || !strings.Contains(err.Error(), "no token for other.azurecr.io") {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+}
+
+func TestScopeMapTokenConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ScopeMapTokenConfig
+		want   string
+	}{
+		{"missing name", ScopeMapTokenConfig{Source: SecretSourceEnv, Value: "X"}, "requires a token name"},
+		{"missing value", ScopeMapTokenConfig{Name: "partner", Source: SecretSourceFile}, "requires a value"},
+		{"own helper", ScopeMapTokenConfig{Source: SecretSourceStore, Value: HelperName}, "cannot use the acr helper"},
+		{"empty secret", ScopeMapTokenConfig{Name: "partner", Source: SecretSourceEnv, Value: "UNSET_PARTNER_TOKEN"}, "is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := tt.config.Credentials(context.Background(), "myregistry.azurecr.io")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
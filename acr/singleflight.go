@@ -0,0 +1,70 @@
+package acr
+
+import (
+	"errors"
+	"sync"
+)
+
+// errFlightPanicked is returned to waiters when the coalesced call panicked
+var errFlightPanicked = errors.New("credential acquisition panicked")
+
+// flightGroup coalesces concurrent credential acquisitions with the same key,
+// so that Docker or BuildKit firing parallel lookups for one registry cause a
+// single Azure AD request and a single exchange. Results are not retained
+// once the call completes; reuse across calls is the job of the token cache.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is an acquisition in progress
+type flightCall struct {
+	done chan struct{}
+
+	// waiters counts callers sharing the result besides the first
+	waiters int
+
+	username string
+	secret   string
+	err      error
+}
+
+// do runs fn for key unless a call for key is already in flight, in which
+// case it waits for that call and returns its result or error
+func (g *flightGroup) do(key string, fn func() (string, string, error)) (string, string, error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		call.waiters++
+		g.mu.Unlock()
+		<-call.done
+		return call.username, call.secret, call.err
+	}
+
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	call := &flightCall{done: make(chan struct{}), err: errFlightPanicked}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	// Release waiters even if fn panics; they then see errFlightPanicked
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.username, call.secret, call.err = fn()
+	return call.username, call.secret, call.err
+}
+
+// waiting returns the number of callers waiting for the call in flight for key
+func (g *flightGroup) waiting(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		return call.waiters
+	}
+	return -1
+}
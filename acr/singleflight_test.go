@@ -0,0 +1,187 @@
+package acr
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingAuthenticator counts Azure AD requests and exchanges and blocks
+// each request until release is closed
+type blockingAuthenticator struct {
+	fakeAuthenticator
+	release   chan struct{}
+	tokens    atomic.Int32
+	exchanges atomic.Int32
+}
+
+func newBlockingAuthenticator(fake fakeAuthenticator) *blockingAuthenticator {
+	return &blockingAuthenticator{fakeAuthenticator: fake, release: make(chan struct{})}
+}
+
+func (b *blockingAuthenticator) GetAzureAccessToken() (string, error) {
+	b.tokens.Add(1)
+	<-b.release
+	return b.fakeAuthenticator.GetAzureAccessToken()
+}
+
+func (b *blockingAuthenticator) ExchangeForACRToken(registryHost, tenantID, azureToken string) (string, error) {
+	b.exchanges.Add(1)
+	return b.fakeAuthenticator.ExchangeForACRToken(registryHost, tenantID, azureToken)
+}
+
+type getResult struct {
+	username, secret string
+	err              error
+}
+
+// concurrentGets starts n Get calls for serverURL, waits until they all share
+// a single call in flight and then lets the authenticator respond
+func concurrentGets(t *testing.T, helper *ACRHelper, auth *blockingAuthenticator, serverURL string, n int) []getResult {
+	t.Helper()
+
+	results := make([]getResult, n)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			username, secret, err := helper.Get(serverURL)
+			results[i] = getResult{username, secret, err}
+		}()
+	}
+
+	host, _, err := helper.validator.ParseAndNormalize(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := helper.refreshTokenCacheKey(host)
+	deadline := time.Now().Add(5 * time.Second)
+	for helper.flights.waiting(key) != n-1 {
+		if time.Now().After(deadline) {
+			close(auth.release)
+			t.Fatalf("expected %d waiters, got %d", n-1, helper.flights.waiting(key))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(auth.release)
+	wg.Wait()
+	return results
+}
+
+func TestGet_CoalescesConcurrentRequests(t *testing.T) {
+	auth := newBlockingAuthenticator(*successAuthenticator())
+	helper := NewACRHelperWithAuthenticator(auth)
+
+	results := concurrentGets(t, helper, auth, "myregistry.azurecr.io", 20)
+
+	if got := auth.tokens.Load(); got != 1 {
+		t.Errorf("expected 1 Azure AD request, got %d", got)
+	}
+	if got := auth.exchanges.Load(); got != 1 {
+		t.Errorf("expected 1 exchange, got %d", got)
+	}
+	for _, result := range results {
+		if result.err != nil || result.username != ACRRefreshTokenUsername || result.secret != "fake-refresh-token-12345" {
+			t.Errorf("expected shared credentials, got %+v", result)
+		}
+	}
+
+	// Completed calls are not reused without a cache
+	if _, _, err := helper.Get("myregistry.azurecr.io"); err != nil {
+		t.Fatal(err)
+	}
+	if got := auth.exchanges.Load(); got != 2 {
+		t.Errorf("expected a new exchange after the call completed, got %d", got)
+	}
+}
+
+func TestGet_CoalescedRequestsShareError(t *testing.T) {
+	fake := *successAuthenticator()
+	fake.refreshTokenErr = errors.New("exchange failed: 401")
+	auth := newBlockingAuthenticator(fake)
+	helper := NewACRHelperWithAuthenticator(auth)
+
+	results := concurrentGets(t, helper, auth, "https://myregistry.azurecr.io", 10)
+
+	if got := auth.exchanges.Load(); got != 1 {
+		t.Errorf("expected 1 exchange, got %d", got)
+	}
+	for _, result := range results {
+		var exchangeErr *ACRTokenExchangeError
+		if !errors.As(result.err, &exchangeErr) {
+			t.Errorf("expected shared exchange error, got %+v", result)
+		}
+	}
+}
+
+func TestGet_DoesNotCoalesceDifferentRegistries(t *testing.T) {
+	auth := newBlockingAuthenticator(*successAuthenticator())
+	helper := NewACRHelperWithAuthenticator(auth)
+
+	var wg sync.WaitGroup
+	for _, registry := range []string{"first.azurecr.io", "second.azurecr.io"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := helper.Get(registry); err != nil {
+				t.Errorf("expected no error for %s, got: %v", registry, err)
+			}
+		}()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for auth.tokens.Load() != 2 {
+		if time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(auth.release)
+	wg.Wait()
+
+	if got := auth.exchanges.Load(); got != 2 {
+		t.Errorf("expected one exchange per registry, got %d", got)
+	}
+}
+
+func TestFlightGroup_PanicReleasesWaiters(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+
+	go func() {
+		defer func() { _ = recover() }()
+		_, _, _ = g.do("key", func() (string, string, error) {
+			close(started)
+			<-proceed
+			panic("boom")
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, _, err := g.do("key", func() (string, string, error) { return "", "", nil })
+		done <- err
+	}()
+	for g.waiting("key") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(proceed)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errFlightPanicked) {
+			t.Errorf("expected errFlightPanicked, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter was not released")
+	}
+	if g.waiting("key") != -1 {
+		t.Error("expected the call to be removed")
+	}
+}
@@ -0,0 +1,170 @@
+package acr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Upper bound for response bodies inspected during tenant discovery
+const maxDiscoveryBodySize = 64 * 1024
+
+// Tenant GUID, the first path segment of an Azure AD authority URL
+var tenantIDRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Azure AD authority hosts of the public and sovereign clouds
+var authorityHosts = map[string]bool{
+	"login.microsoftonline.com":        true,
+	"login.microsoftonline.us":         true,
+	"login.chinacloudapi.cn":           true,
+	"login.partner.microsoftonline.cn": true,
+}
+
+// TenantDiscoverer is implemented by authenticators that can determine the
+// tenant owning a registry without credentials
+type TenantDiscoverer interface {
+	DiscoverTenant(registryHost string) (string, error)
+}
+
+// authorityTenant returns the tenant of an Azure AD authority URL such as
+// https://login.microsoftonline.com/<tenant>, or "" for other URLs and
+// multi-tenant authorities like /common
+func authorityTenant(authority string) string {
+	parsed, err := url.Parse(authority)
+	if err != nil || parsed.Scheme != "https" || !authorityHosts[strings.ToLower(parsed.Host)] {
+		return ""
+	}
+
+	tenant, _, _ := strings.Cut(strings.TrimPrefix(parsed.Path, "/"), "/")
+	if !tenantIDRegex.MatchString(tenant) {
+		return ""
+	}
+	return strings.ToLower(tenant)
+}
+
+// tenantCache remembers discovered tenants per registry host
+type tenantCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+func (c *tenantCache) get(host string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tenant, ok := c.entries[host]
+	return tenant, ok
+}
+
+func (c *tenantCache) put(host, tenant string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]string{}
+	}
+	c.entries[host] = tenant
+}
+
+// DiscoverTenant determines the tenant owning a registry from its
+// unauthenticated responses: the Azure AD authority in the /v2/ challenge
+// (the authorization_uri or authorization parameter Azure services add for
+// challenge-based authentication), then the error returned by an exchange
+// request without a token. Results are cached per host.
+func (a *AzureAuthenticator) DiscoverTenant(registryHost string) (string, error) {
+	if tenant, ok := a.tenants.get(registryHost); ok {
+		return tenant, nil
+	}
+
+	if challenge, err := a.DiscoverChallenge(registryHost); err == nil {
+		if tenant := challengeTenant(challenge); tenant != "" {
+			a.tenants.put(registryHost, tenant)
+			return tenant, nil
+		}
+	}
+
+	tenant, err := a.probeExchangeTenant(registryHost)
+	if err != nil {
+		return "", err
+	}
+
+	a.tenants.put(registryHost, tenant)
+	return tenant, nil
+}
+
+// challengeTenant returns the tenant of the authority advertised in a challenge
+func challengeTenant(challenge *AuthChallenge) string {
+	for _, param := range []string{"authorization_uri", "authorization"} {
+		if tenant := authorityTenant(challenge.Params[param]); tenant != "" {
+			return tenant
+		}
+	}
+	return ""
+}
+
+// exchangeError is the error body of the ACR exchange endpoint
+type exchangeError struct {
+	Errors []struct {
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		Tenant   string `json:"tenant"`
+		TenantID string `json:"tenantId"`
+	} `json:"errors"`
+}
+
+// probeExchangeTenant sends an exchange request without an access token and
+// reads the tenant from the response: an authority in a Bearer challenge,
+// a tenant field of an error, or an authority URL in an error message.
+// Anything else, including bare GUIDs in free text, is ignored.
+func (a *AzureAuthenticator) probeExchangeTenant(registryHost string) (string, error) {
+	exchangeURL, service := a.resolveExchangeEndpoint(registryHost)
+
+	ctx, cancel := context.WithTimeout(context.Background(), ChallengeProbeTimeout)
+	defer cancel()
+
+	formData := url.Values{
+		"grant_type": []string{"access_token"},
+		"service":    []string{service},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", exchangeURL, strings.NewReader(formData.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create tenant discovery request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("tenant discovery request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		if challenge, err := ParseBearerChallenge(header); err == nil {
+			if tenant := challengeTenant(challenge); tenant != "" {
+				return tenant, nil
+			}
+		}
+	}
+
+	var body exchangeError
+	if json.NewDecoder(io.LimitReader(resp.Body, maxDiscoveryBodySize)).Decode(&body) == nil {
+		for _, e := range body.Errors {
+			for _, candidate := range []string{e.Tenant, e.TenantID} {
+				if tenantIDRegex.MatchString(candidate) {
+					return strings.ToLower(candidate), nil
+				}
+			}
+			for _, field := range strings.Fields(e.Message) {
+				if tenant := authorityTenant(strings.TrimRight(field, ".,;)\"'")); tenant != "" {
+					return tenant, nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("registry %s does not reveal its tenant", registryHost)
+}
@@ -0,0 +1,147 @@
+package acr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testTenant = "72f988bf-86f1-41af-91ab-2d7cd011db47"
+
+func TestAuthorityTenant(t *testing.T) {
+	tests := map[string]string{
+		"https://login.microsoftonline.com/" + testTenant:                                       testTenant,
+		"https://login.microsoftonline.us/" + strings.ToUpper(testTenant) + "/oauth2/authorize": testTenant,
+		"https://login.microsoftonline.com/common/oauth2/authorize":                             "",
+		"https://login.example.com/" + testTenant:                                               "",
+		"http://login.microsoftonline.com/" + testTenant:                                        "",
+		"tenant_id=" + testTenant:                                                               "",
+		"":                                                                                      "",
+	}
+	for input, want := range tests {
+		if got := authorityTenant(input); got != want {
+			t.Errorf("authorityTenant(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestDiscoverTenant_FromChallenge(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != RegistryProbePath {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/oauth2/token",service="svc",`+
+			`authorization_uri="https://login.microsoftonline.com/`+testTenant+`"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+
+	tenant, err := auth.DiscoverTenant(strings.TrimPrefix(server.URL, "https://"))
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if tenant != testTenant {
+		t.Errorf("expected %s, got %s", testTenant, tenant)
+	}
+}
+
+func TestDiscoverTenant_Cached(t *testing.T) {
+	var probes atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/oauth2/token",`+
+			`authorization="https://login.microsoftonline.com/`+testTenant+`/"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	for i := 0; i < 2; i++ {
+		tenant, err := auth.DiscoverTenant(host)
+		if err != nil {
+			t.Fatalf("expected no error, got: %v", err)
+		}
+		if tenant != testTenant {
+			t.Errorf("expected %s, got %s", testTenant, tenant)
+		}
+	}
+	if probes.Load() != 1 {
+		t.Errorf("expected discovered tenant to be cached, got %d probes", probes.Load())
+	}
+}
+
+// fakeACRRegistry behaves like ACR for unauthenticated clients: a /v2/
+// challenge without tenant and an exchange error revealing it as given
+func fakeACRRegistry(t *testing.T, exchangeBody string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var exchanges atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimPrefix(server.URL, "https://")
+		switch r.URL.Path {
+		case RegistryProbePath:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="https://`+host+`/oauth2/token",service="`+host+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case ACRTokenExchangePath:
+			exchanges.Add(1)
+			if r.FormValue("access_token") != "" {
+				t.Error("discovery must not send an access token")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(exchangeBody))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &exchanges
+}
+
+func TestDiscoverTenant_FromExchangeError(t *testing.T) {
+	bodies := map[string]string{
+		"tenant field":         `{"errors":[{"code":"UNAUTHORIZED","message":"authentication required","tenant":"` + strings.ToUpper(testTenant) + `"}]}`,
+		"authority in message": `{"errors":[{"code":"UNAUTHORIZED","message":"sign in at https://login.microsoftonline.com/` + testTenant + `/oauth2/authorize."}]}`,
+	}
+
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			server, exchanges := fakeACRRegistry(t, body)
+			auth := NewAzureAuthenticator()
+			auth.httpClient = server.Client()
+			host := strings.TrimPrefix(server.URL, "https://")
+
+			for i := 0; i < 2; i++ {
+				tenant, err := auth.DiscoverTenant(host)
+				if err != nil || tenant != testTenant {
+					t.Fatalf("expected %s, got %q, %v", testTenant, tenant, err)
+				}
+			}
+			if exchanges.Load() != 1 {
+				t.Errorf("expected discovered tenant to be cached, got %d probes", exchanges.Load())
+			}
+		})
+	}
+}
+
+func TestDiscoverTenant_NotRevealed(t *testing.T) {
+	// GUIDs outside a tenant field or an authority are not taken as the tenant
+	server, _ := fakeACRRegistry(t, `{"errors":[{"code":"UNAUTHORIZED","message":"tenant `+testTenant+` required","detail":"`+testTenant+`"}]}`)
+
+	auth := NewAzureAuthenticator()
+	auth.httpClient = server.Client()
+
+	if _, err := auth.DiscoverTenant(strings.TrimPrefix(server.URL, "https://")); err == nil {
+		t.Fatal("expected error when the registry does not reveal its tenant")
+	}
+}
@@ -0,0 +1,139 @@
+package acr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// TransportConfig describes how the helper connects to Azure AD and ACR.
+// The zero value uses the system trust store and the standard proxy
+// environment variables (HTTPS_PROXY, NO_PROXY).
+type TransportConfig struct {
+	// ProxyURL overrides the proxy used for all requests
+	ProxyURL string `json:"proxyUrl,omitempty"`
+
+	// NoProxy overrides the hosts that bypass the proxy (NO_PROXY syntax).
+	// Loopback and link-local addresses, e.g. the instance metadata
+	// service, always bypass it.
+	NoProxy string `json:"noProxy,omitempty"`
+
+	// CAFiles are PEM bundles trusted in addition to the system roots
+	CAFiles []string `json:"caFiles,omitempty"`
+
+	// ClientCertFile and ClientKeyFile enable mutual TLS
+	ClientCertFile string `json:"clientCert,omitempty"`
+	ClientKeyFile  string `json:"clientKey,omitempty"`
+
+	// MinTLSVersion is "1.2" or "1.3"; empty defaults to TLS 1.2
+	MinTLSVersion string `json:"minTlsVersion,omitempty"`
+}
+
+// NewHTTPClient builds the HTTP client used for all token requests
+func NewHTTPClient(tc TransportConfig) (*http.Client, error) {
+	tlsConfig, err := tc.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := tc.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   TokenRequestTimeout,
+	}, nil
+}
+
+func (tc TransportConfig) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	proxyConfig := httpproxy.FromEnvironment()
+
+	if tc.ProxyURL != "" {
+		parsed, err := url.Parse(tc.ProxyURL)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", tc.ProxyURL)
+		}
+		proxyConfig.HTTPProxy = tc.ProxyURL
+		proxyConfig.HTTPSProxy = tc.ProxyURL
+	}
+	if tc.NoProxy != "" {
+		proxyConfig.NoProxy = tc.NoProxy
+	}
+
+	proxyForURL := proxyConfig.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		// Managed identity endpoints are only reachable directly
+		if ip := net.ParseIP(req.URL.Hostname()); ip != nil && (ip.IsLoopback() || ip.IsLinkLocalUnicast()) {
+			return nil, nil
+		}
+		return proxyForURL(req.URL)
+	}, nil
+}
+
+func (tc TransportConfig) tlsConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(tc.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: minVersion}
+
+	if len(tc.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		for _, caFile := range tc.CAFiles {
+			pem, err := os.ReadFile(filepath.Clean(caFile))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificates found in CA file %s", caFile)
+			}
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if tc.ClientCertFile != "" || tc.ClientKeyFile != "" {
+		if tc.ClientCertFile == "" || tc.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must be configured together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(tc.ClientCertFile, tc.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum TLS version %q: must be 1.2 or 1.3", version)
+	}
+}
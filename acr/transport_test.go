@@ -0,0 +1,137 @@
+package acr
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeServerCA stores the httptest server certificate as a PEM file
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+	return path
+}
+
+func TestNewHTTPClient_TrustsExtraCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(TransportConfig{})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("expected TLS verification failure without extra CA")
+	}
+
+	client, err = NewHTTPClient(TransportConfig{CAFiles: []string{writeServerCA(t, server)}})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected request to succeed with extra CA, got: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewHTTPClient_ProxyAndNoProxy(t *testing.T) {
+	client, err := NewHTTPClient(TransportConfig{
+		ProxyURL: "http://proxy.corp.example:3128",
+		NoProxy:  "internal.example",
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	proxy := client.Transport.(*http.Transport).Proxy
+
+	req, _ := http.NewRequest("GET", "https://myregistry.azurecr.io/v2/", nil)
+	proxyURL, err := proxy(req)
+	if err != nil || proxyURL == nil || proxyURL.Host != "proxy.corp.example:3128" {
+		t.Errorf("expected request to use the proxy, got: %v (%v)", proxyURL, err)
+	}
+
+	req, _ = http.NewRequest("GET", "https://api.internal.example/", nil)
+	if proxyURL, _ := proxy(req); proxyURL != nil {
+		t.Errorf("expected NO_PROXY host to bypass the proxy, got: %v", proxyURL)
+	}
+}
+
+func TestNewHTTPClient_ProxyExemptions(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy.example:3128")
+	t.Setenv("HTTP_PROXY", "http://env-proxy.example:3128")
+	t.Setenv("NO_PROXY", "")
+
+	tests := []struct {
+		name    string
+		config  TransportConfig
+		url     string
+		proxied bool
+	}{
+		{name: "environment proxy", url: "https://myregistry.azurecr.io/v2/", proxied: true},
+		{name: "no proxy without proxy URL", config: TransportConfig{NoProxy: "azurecr.io"}, url: "https://myregistry.azurecr.io/v2/"},
+		{name: "instance metadata", url: "http://169.254.169.254/metadata/identity/oauth2/token"},
+		{name: "instance metadata with proxy URL", config: TransportConfig{ProxyURL: "http://proxy.corp.example:3128"}, url: "http://169.254.169.254/metadata/identity/oauth2/token"},
+		{name: "loopback", config: TransportConfig{ProxyURL: "http://proxy.corp.example:3128"}, url: "http://127.0.0.1:40342/metadata/identity/oauth2/token"},
+		{name: "IPv6 loopback", url: "http://[::1]:8484/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient(tt.config)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			proxyURL, err := client.Transport.(*http.Transport).Proxy(req)
+			if err != nil {
+				t.Fatalf("expected no error, got: %v", err)
+			}
+			if proxied := proxyURL != nil; proxied != tt.proxied {
+				t.Errorf("expected proxied=%v, got proxy %v", tt.proxied, proxyURL)
+			}
+		})
+	}
+}
+
+func TestNewHTTPClient_MinTLSVersion(t *testing.T) {
+	client, err := NewHTTPClient(TransportConfig{MinTLSVersion: "1.3"})
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+	if v := client.Transport.(*http.Transport).TLSClientConfig.MinVersion; v != tls.VersionTLS13 {
+		t.Errorf("expected TLS 1.3 minimum, got: %x", v)
+	}
+}
+
+func TestNewHTTPClient_InvalidSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		config TransportConfig
+		want   string
+	}{
+		{name: "bad TLS version", config: TransportConfig{MinTLSVersion: "1.0"}, want: "unsupported minimum TLS version"},
+		{name: "missing CA file", config: TransportConfig{CAFiles: []string{"/nonexistent/ca.pem"}}, want: "failed to read CA file"},
+		{name: "cert without key", config: TransportConfig{ClientCertFile: "cert.pem"}, want: "configured together"},
+		{name: "bad proxy", config: TransportConfig{ProxyURL: "://"}, want: "invalid proxy URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHTTPClient(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
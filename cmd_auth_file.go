@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runWriteAuthFile stores static credentials for ACR registries in a
+// containers auth.json (podman, buildah, skopeo) or Docker config.json
+func runWriteAuthFile(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("write-auth-file", "[flags] <registry>...")
+	path := fs.String("file", "", "auth file to update (default: containers auth.json)")
+	docker := fs.Bool("docker", false, "update the Docker config.json instead of the containers auth.json")
+	prune := fs.Bool("prune-expired", false, "remove ACR entries whose refresh token has expired")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 && !*prune {
+		fs.Usage()
+		return fmt.Errorf("no registries given")
+	}
+
+	if *path == "" {
+		var err error
+		if *docker {
+			*path, err = acr.DefaultDockerConfigFile()
+		} else {
+			*path, err = acr.DefaultContainersAuthFile()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	authFile, err := acr.LoadAuthFile(*path)
+	if err != nil {
+		return err
+	}
+
+	if *prune {
+		for _, host := range authFile.PruneExpired(helper.RegistryValidator(), time.Now()) {
+			fmt.Printf("Removed expired entry for %s\n", host)
+		}
+	}
+
+	validator := helper.RegistryValidator()
+	for _, registry := range fs.Args() {
+		host, _, err := validator.ParseAndNormalize(registry)
+		if err != nil {
+			return err
+		}
+
+		username, secret, err := helper.Get(host)
+		if err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+		if secret == "" {
+			// Anonymous pull: no static entry needed
+			fmt.Printf("Skipped %s (anonymous pull)\n", host)
+			continue
+		}
+
+		authFile.SetCredentials(host, username, secret)
+		fmt.Printf("Updated entry for %s\n", host)
+	}
+
+	if err := authFile.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", authFile.Path())
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runBatch retrieves credentials for several registries in one invocation
+// and prints the per-registry results as a single JSON document
+func runBatch(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("batch", "[flags] [registry...]  (reads registries from stdin if none are given)")
+	workers := fs.Int("workers", acr.DefaultBatchWorkers, "maximum number of concurrent token exchanges")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	registries := fs.Args()
+	if len(registries) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				registries = append(registries, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read registries from stdin: %w", err)
+		}
+	}
+	if len(registries) == 0 {
+		fs.Usage()
+		return fmt.Errorf("no registries given")
+	}
+
+	result := helper.GetBatch(registries, *workers)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return err
+	}
+
+	if failed := result.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d registries failed", failed, len(result.Registries))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// requiredRoles names the built-in role granting each checked action
+var requiredRoles = map[string]string{
+	acr.ActionPull: "AcrPull",
+	acr.ActionPush: "AcrPush",
+}
+
+// runCheck verifies that the current identity may pull from or push to a
+// repository before a docker command fails with an opaque 401 or 403
+func runCheck(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("check", "[flags] <registry>")
+	repo := fs.String("repo", "", "repository to check (default: any repository, i.e. registry-wide permissions)")
+	action := fs.String("action", acr.ActionPull, "action to check: pull or push")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one registry")
+	}
+
+	check, err := helper.CheckPermission(fs.Arg(0), *repo, *action)
+	if err != nil {
+		return err
+	}
+
+	repository := check.Repository
+	if *repo == "" {
+		repository += " (any repository)"
+	}
+	granted := strings.Join(check.Granted, ", ")
+	if granted == "" {
+		granted = "none"
+	}
+
+	fmt.Printf("Registry:   %s\n", check.Registry)
+	fmt.Printf("Repository: %s\n", repository)
+	fmt.Printf("Granted:    %s\n", granted)
+
+	if !check.Allowed() {
+		return fmt.Errorf(
+			"%s permission missing on %s/%s: the registry granted %s. "+
+				"Assign the %s role (or a role with the equivalent repository permission) "+
+				"on the registry to the identity, or use a token whose scope map includes %s",
+			check.Action, check.Registry, check.Repository, granted, requiredRoles[check.Action], check.Action,
+		)
+	}
+
+	fmt.Printf("OK: %s is allowed\n", check.Action)
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runClearFailures forgets cached failures, so the next request for the
+// registries retries at once instead of after the failure cache TTL
+func runClearFailures(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("clear-failures", "<registry>...")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no registries given")
+	}
+
+	for _, registry := range fs.Args() {
+		if err := helper.ClearFailure(registry); err != nil {
+			return fmt.Errorf("%s: %w", registry, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runConfigureDocker registers the helper in the Docker config.json, either
+// per registry (credHelpers) or globally (credsStore)
+func runConfigureDocker(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("configure-docker", "[flags] [registry...]")
+	path := fs.String("file", "", "Docker config file (default: $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	credsStore := fs.Bool("creds-store", false, "register the helper as credsStore for all registries")
+	remove := fs.Bool("remove", false, "unregister the helper instead of registering it")
+	dryRun := fs.Bool("dry-run", false, "print the resulting configuration without writing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 && !*credsStore {
+		fs.Usage()
+		return fmt.Errorf("no registries given (use --creds-store to configure all registries)")
+	}
+
+	if *path == "" {
+		var err error
+		if *path, err = acr.DefaultDockerConfigFile(); err != nil {
+			return err
+		}
+	}
+
+	// Validate every host before touching the file
+	validator := helper.RegistryValidator()
+	hosts := make([]string, 0, fs.NArg())
+	for _, registry := range fs.Args() {
+		host, _, err := validator.ParseAndNormalize(registry)
+		if err != nil {
+			return err
+		}
+		hosts = append(hosts, host)
+	}
+
+	cfg, err := acr.LoadDockerConfig(*path)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	if *credsStore {
+		if *remove {
+			changed = cfg.RemoveCredsStore() || changed
+		} else {
+			changed = cfg.SetCredsStore() || changed
+		}
+	}
+	for _, host := range hosts {
+		if *remove {
+			changed = cfg.RemoveCredHelper(host) || changed
+		} else {
+			changed = cfg.SetCredHelper(host) || changed
+		}
+	}
+
+	if *dryRun {
+		data, err := cfg.Marshal()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if !changed {
+		fmt.Printf("%s is already up to date\n", cfg.Path())
+		return nil
+	}
+
+	backup, err := cfg.Save()
+	if err != nil {
+		return err
+	}
+	if backup != "" {
+		fmt.Printf("Backed up previous configuration to %s\n", backup)
+	}
+	fmt.Printf("Updated %s\n", cfg.Path())
+
+	return nil
+}
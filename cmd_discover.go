@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runDiscover lists the registries visible to the current Azure identity and
+// optionally adds them to the helper or Docker configuration
+func runDiscover(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("discover", "[flags]")
+	subscriptions := fs.String("subscription", "", "comma separated subscription IDs (default: all accessible subscriptions)")
+	output := fs.String("output", "table", "output format: table or json")
+	writeConfig := fs.Bool("write-config", false, "add discovered registries to the helper configuration file")
+	configureDocker := fs.Bool("configure-docker", false, "register the helper for discovered registries in the Docker config.json")
+	dockerConfig := fs.String("docker-config", "", "Docker config file (default: $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unsupported output format %q: must be table or json", *output)
+	}
+
+	var subscriptionIDs []string
+	for _, id := range strings.Split(*subscriptions, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			subscriptionIDs = append(subscriptionIDs, id)
+		}
+	}
+
+	registries, err := helper.DiscoverRegistries(subscriptionIDs)
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if registries == nil {
+			registries = []acr.DiscoveredRegistry{}
+		}
+		if err := enc.Encode(registries); err != nil {
+			return err
+		}
+	} else {
+		printRegistryTable(registries)
+	}
+
+	// Status messages go to stderr so JSON output stays parseable
+	if *writeConfig {
+		path, err := acr.DefaultConfigFile()
+		if err != nil {
+			return err
+		}
+		added, err := acr.AddRegistriesToConfigFile(path, registries, helper.RegistryValidator())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Added %d registries to %s\n", len(added), path)
+	}
+
+	if *configureDocker {
+		if *dockerConfig == "" {
+			if *dockerConfig, err = acr.DefaultDockerConfigFile(); err != nil {
+				return err
+			}
+		}
+		if err := configureDiscoveredRegistries(*dockerConfig, registries, helper.RegistryValidator()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printRegistryTable(registries []acr.DiscoveredRegistry) {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOGIN SERVER\tRESOURCE GROUP\tLOCATION\tSKU\tPULL\tPUSH")
+	for _, r := range registries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.LoginServer, r.ResourceGroup, r.Location, r.SKU, yesNo(r.Pull), yesNo(r.Push))
+	}
+	w.Flush()
+}
+
+// configureDiscoveredRegistries adds a credHelpers entry for every discovered
+// registry
+func configureDiscoveredRegistries(path string, registries []acr.DiscoveredRegistry, validator *acr.RegistryValidator) error {
+	cfg, err := acr.LoadDockerConfig(path)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, r := range registries {
+		if _, _, err := validator.ParseAndNormalize(r.LoginServer); err != nil {
+			continue
+		}
+		changed = cfg.SetCredHelper(r.LoginServer) || changed
+	}
+
+	if !changed {
+		fmt.Fprintf(os.Stderr, "%s is already up to date\n", cfg.Path())
+		return nil
+	}
+
+	backup, err := cfg.Save()
+	if err != nil {
+		return err
+	}
+	if backup != "" {
+		fmt.Fprintf(os.Stderr, "Backed up previous configuration to %s\n", backup)
+	}
+	fmt.Fprintf(os.Stderr, "Updated %s\n", cfg.Path())
+
+	return nil
+}
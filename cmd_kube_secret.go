@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+const (
+	// Retry delay after a failed refresh in watch mode
+	kubeSecretRetryInterval = time.Minute
+
+	// Refresh interval when the token expiry is unknown
+	kubeSecretFallbackInterval = time.Hour
+)
+
+type kubeSecretOptions struct {
+	name          string
+	namespace     string
+	format        string
+	output        string
+	execHook      string
+	refreshBefore time.Duration
+}
+
+// runKubeSecret generates a kubernetes.io/dockerconfigjson Secret manifest
+// and, with --watch, keeps regenerating it ahead of token expiry
+func runKubeSecret(helper *acr.ACRHelper, args []string) error {
+	opts := kubeSecretOptions{}
+	fs := newFlagSet("kube-secret", "[flags] <registry>...")
+	fs.StringVar(&opts.name, "name", "acr-pull-secret", "secret name")
+	fs.StringVar(&opts.namespace, "namespace", "", "secret namespace (omitted if empty)")
+	fs.StringVar(&opts.format, "format", "yaml", "manifest format: yaml or json")
+	fs.StringVar(&opts.output, "output", "", "write the manifest to this file instead of stdout")
+	fs.StringVar(&opts.execHook, "exec", "", "shell command receiving the manifest on stdin, e.g. 'kubectl apply -f -'")
+	watch := fs.Bool("watch", false, "keep regenerating the secret before the refresh token expires")
+	fs.DurationVar(&opts.refreshBefore, "refresh-before", 15*time.Minute, "how long before expiry to regenerate in watch mode")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no registries given")
+	}
+	if opts.format != "yaml" && opts.format != "json" {
+		return fmt.Errorf("unsupported format %q: must be yaml or json", opts.format)
+	}
+
+	if !*watch {
+		_, err := writeKubeSecret(context.Background(), helper, fs.Args(), opts)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		next := time.Now().Add(kubeSecretRetryInterval)
+
+		secret, err := writeKubeSecret(ctx, helper, fs.Args(), opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kube-secret: refresh failed, retrying in %s: %v\n", kubeSecretRetryInterval, err)
+		} else {
+			next = secret.RefreshAt(time.Now(), opts.refreshBefore, kubeSecretRetryInterval, kubeSecretFallbackInterval)
+			fmt.Fprintf(os.Stderr, "kube-secret: next refresh at %s\n", next.Format(time.RFC3339))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// writeKubeSecret builds the secret and delivers it to the exec hook, the
+// output file or stdout
+func writeKubeSecret(ctx context.Context, helper *acr.ACRHelper, registries []string, opts kubeSecretOptions) (*acr.PullSecret, error) {
+	secret, err := acr.NewPullSecret(opts.name, opts.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	validator := helper.RegistryValidator()
+	for _, registry := range registries {
+		host, _, err := validator.ParseAndNormalize(registry)
+		if err != nil {
+			return nil, err
+		}
+
+		// A cached token must outlive the next refresh, or watch mode would
+		// re-apply the same token every retry interval
+		username, password, err := helper.GetValidFor(host, opts.refreshBefore+kubeSecretRetryInterval)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+		if !secret.AddCredentials(host, username, password) {
+			fmt.Fprintf(os.Stderr, "Skipped %s (anonymous pull)\n", host)
+		}
+	}
+
+	var manifest []byte
+	if opts.format == "json" {
+		manifest, err = secret.JSON()
+	} else {
+		manifest, err = secret.YAML()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.execHook != "":
+		cmd := acr.CommandFor(ctx, opts.execHook)
+		cmd.Stdin = bytes.NewReader(manifest)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("exec hook failed: %w", err)
+		}
+	case opts.output != "":
+		if err := acr.WriteFileAtomic(opts.output, manifest, 0o600); err != nil {
+			return nil, err
+		}
+	default:
+		if _, err := os.Stdout.Write(manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	return secret, nil
+}
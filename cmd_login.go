@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runLogin signs a user in once; later get requests reuse the cached tokens
+// silently until the sign-in expires
+func runLogin(cfg *acr.Config, args []string) error {
+	fs := newFlagSet("login", "[flags]")
+	flow := fs.String("flow", acr.CredentialDeviceCode, "sign-in flow: devicecode or browser")
+	tenant := fs.String("tenant", "", "tenant to sign in to (default: AZURE_TENANT_ID, else organizations)")
+	clientID := fs.String("client-id", "", "application (client) ID to sign in with (default: AZURE_CLIENT_ID, else the Azure development client)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	record, err := acr.Login(cfg, *flow, *tenant, *clientID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Signed in as %s (tenant %s)\n", record.Username, record.TenantID)
+	return nil
+}
+
+// runLogout forgets the account signed in with login and the tokens cached
+// for it
+func runLogout(cfg *acr.Config, args []string) error {
+	fs := newFlagSet("logout", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	removed, err := acr.Logout(cfg)
+	if removed {
+		fmt.Println("Signed out")
+	} else if err == nil {
+		fmt.Println("Not signed in")
+	}
+	return err
+}
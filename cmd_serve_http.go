@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runServeHTTP serves credentials for the given registries to local clients,
+// e.g. build containers that cannot reach the VM's managed identity
+func runServeHTTP(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("serve-http", "[flags] <registry>...")
+	listen := fs.String("listen", "127.0.0.1:8484", "loopback address or unix:<path> to listen on")
+	secretFile := fs.String("secret-file", "", "file containing the shared secret clients must send (default: "+acr.EnvBrokerSecret+")")
+	allowUIDs := fs.String("allow-uid", "", "comma-separated users allowed to connect over a unix socket without the secret (Linux only)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no registries given")
+	}
+
+	opts := acr.BrokerOptions{Registries: fs.Args(), Secret: os.Getenv(acr.EnvBrokerSecret), ErrorLog: os.Stderr}
+	if opts.Secret == "" && *secretFile != "" {
+		data, err := os.ReadFile(filepath.Clean(*secretFile))
+		if err != nil {
+			return fmt.Errorf("failed to read secret: %w", err)
+		}
+		opts.Secret = strings.TrimSpace(string(data))
+	}
+
+	if *allowUIDs != "" {
+		if !strings.HasPrefix(*listen, "unix:") || !acr.PeerCredentialsSupported {
+			return fmt.Errorf("--allow-uid requires a unix socket on Linux")
+		}
+		for _, field := range strings.Split(*allowUIDs, ",") {
+			uid, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || uid < 0 {
+				return fmt.Errorf("invalid user ID %q", field)
+			}
+			opts.UIDs = append(opts.UIDs, uid)
+		}
+	}
+	if opts.Secret == "" && len(opts.UIDs) == 0 {
+		return fmt.Errorf("a shared secret (--secret-file or %s) or --allow-uid is required", acr.EnvBrokerSecret)
+	}
+
+	handler, err := acr.NewBrokerHandler(helper, opts)
+	if err != nil {
+		return err
+	}
+
+	listener, err := acr.ListenBroker(*listen)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "serve-http: serving credentials for %s on %s\n", strings.Join(fs.Args(), ", "), *listen)
+	return acr.ServeBroker(ctx, listener, handler)
+}
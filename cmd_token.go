@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// runToken prints registry credentials in formats suited for shell scripts
+// and CI systems
+func runToken(helper *acr.ACRHelper, args []string) error {
+	fs := newFlagSet("token", "[flags] <registry>...")
+	output := fs.String("output", acr.OutputRaw,
+		"output format: raw, env, auth, docker-auth-config, github, gitlab")
+	prefix := fs.String("prefix", acr.DefaultEnvPrefix, "variable name prefix for env, github and gitlab output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no registries given")
+	}
+	if fs.NArg() > 1 && *output != acr.OutputDockerAuthConfig {
+		return fmt.Errorf("output %q supports a single registry; use %s for several", *output, acr.OutputDockerAuthConfig)
+	}
+
+	validator := helper.RegistryValidator()
+	creds := make([]acr.RegistryCredentials, 0, fs.NArg())
+	for _, registry := range fs.Args() {
+		host, _, err := validator.ParseAndNormalize(registry)
+		if err != nil {
+			return err
+		}
+
+		username, secret, err := helper.Get(host)
+		if err != nil {
+			return fmt.Errorf("%s: %w", host, err)
+		}
+		if secret == "" {
+			return fmt.Errorf("%s allows anonymous pull, no token was issued", host)
+		}
+		creds = append(creds, acr.RegistryCredentials{Registry: host, Username: username, Secret: secret})
+	}
+
+	var (
+		out string
+		err error
+	)
+	switch *output {
+	case acr.OutputRaw:
+		out = creds[0].Secret + "\n"
+	case acr.OutputEnv:
+		out, err = acr.FormatShellExports(creds[0], *prefix)
+	case acr.OutputAuth:
+		out = creds[0].Auth() + "\n"
+	case acr.OutputDockerAuthConfig:
+		out, err = acr.FormatDockerAuthConfig(creds)
+	case acr.OutputGitLab:
+		out, err = acr.FormatDotenv(creds[0], *prefix)
+	case acr.OutputGitHub:
+		// Masks must reach the runner before any value is exposed
+		if _, err := os.Stdout.WriteString(acr.FormatGitHubMasks(creds[0])); err != nil {
+			return err
+		}
+		return appendGitHubEnv(creds[0], *prefix)
+	default:
+		return fmt.Errorf("unsupported output format %q", *output)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.WriteString(out)
+	return err
+}
+
+// appendGitHubEnv exports the credentials to subsequent workflow steps
+func appendGitHubEnv(creds acr.RegistryCredentials, prefix string) error {
+	path := os.Getenv("GITHUB_ENV")
+	if path == "" {
+		return fmt.Errorf("GITHUB_ENV is not set; github output only works inside GitHub Actions")
+	}
+
+	lines, err := acr.FormatDotenv(creds, prefix)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Clean(path), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open GITHUB_ENV: %w", err)
+	}
+	if _, err := f.WriteString(lines); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write GITHUB_ENV: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mriedmann/acr-docker-credential-helper/acr"
+)
+
+// commands are subcommands beyond the credential helper protocol
+// (store, get, erase, list, version are served by credentials.Serve)
+var commands = map[string]func(helper *acr.ACRHelper, args []string) error{
+	"write-auth-file":  runWriteAuthFile,
+	"configure-docker": runConfigureDocker,
+	"kube-secret":      runKubeSecret,
+	"batch":            runBatch,
+	"token":            runToken,
+	"discover":         runDiscover,
+	"check":            runCheck,
+	"serve-http":       runServeHTTP,
+	"clear-failures":   runClearFailures,
+}
+
+// configCommands are subcommands that only need the configuration
+var configCommands = map[string]func(cfg *acr.Config, args []string) error{
+	"login":  runLogin,
+	"logout": runLogout,
+}
+
+// newFlagSet creates a flag set whose usage output shows the command synopsis
+func newFlagSet(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n", os.Args[0], name, synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -250,3 +251,171 @@ func TestBinary_Get_URLVariants(t *testing.T) {
 		})
 	}
 }
+
+func TestBinary_WriteAuthFile_RejectsNonACR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+
+	cmd := exec.Command(binaryPath, "write-auth-file", "--file", path, "registry-1.docker.io")
+	var errBuf bytes.Buffer
+	cmd.Stderr = &errBuf
+	err := cmd.Run()
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("expected exit code 1, got: %v", err)
+	}
+	if !strings.Contains(errBuf.String(), "not an ACR registry") {
+		t.Errorf("expected 'not an ACR registry' in stderr, got: %s", errBuf.String())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected auth file not to be written, got: %v", err)
+	}
+}
+
+func TestBinary_ConfigureDocker(t *testing.T) {
+	dockerConfig := t.TempDir()
+	path := filepath.Join(dockerConfig, "config.json")
+	if err := os.WriteFile(path, []byte(`{"auths": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, int) {
+		cmd := exec.Command(binaryPath, append([]string{"configure-docker"}, args...)...)
+		cmd.Env = append(os.Environ(), "DOCKER_CONFIG="+dockerConfig)
+		var outBuf bytes.Buffer
+		cmd.Stdout = &outBuf
+		cmd.Stderr = &outBuf
+		err := cmd.Run()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return outBuf.String(), exitErr.ExitCode()
+		}
+		return outBuf.String(), 0
+	}
+
+	if out, code := run("--dry-run", "https://myregistry.azurecr.io"); code != 0 || !strings.Contains(out, `"myregistry.azurecr.io": "acr"`) {
+		t.Fatalf("expected dry run to print the new entry, got (%d): %s", code, out)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"auths": {}}` {
+		t.Errorf("expected dry run not to modify the file, got: %s", data)
+	}
+
+	if out, code := run("myregistry.azurecr.io"); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, out)
+	}
+	if _, err := os.Stat(path + ".bak"); err != nil {
+		t.Errorf("expected backup file: %v", err)
+	}
+
+	var cfg map[string]any
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("config is not valid JSON: %v", err)
+	}
+	if helpers, _ := cfg["credHelpers"].(map[string]any); helpers["myregistry.azurecr.io"] != "acr" {
+		t.Errorf("expected helper to be registered, got: %s", data)
+	}
+
+	if out, code := run("--remove", "myregistry.azurecr.io"); code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, out)
+	}
+	data, _ = os.ReadFile(path)
+	if strings.Contains(string(data), "credHelpers") {
+		t.Errorf("expected credHelpers to be removed, got: %s", data)
+	}
+
+	if out, code := run("registry-1.docker.io"); code != 1 || !strings.Contains(out, "not an ACR registry") {
+		t.Errorf("expected non-ACR registry to be rejected, got (%d): %s", code, out)
+	}
+}
+
+func TestBinary_Token_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "non-ACR registry", args: []string{"registry-1.docker.io"}, want: "not an ACR registry"},
+		{name: "several registries with raw output", args: []string{"a1234.azurecr.io", "b1234.azurecr.io"}, want: "supports a single registry"},
+		{name: "no registries", args: nil, want: "no registries given"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(binaryPath, append([]string{"token"}, tt.args...)...)
+			var errBuf bytes.Buffer
+			cmd.Stderr = &errBuf
+			err := cmd.Run()
+
+			if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+				t.Fatalf("expected exit code 1, got: %v", err)
+			}
+			if !strings.Contains(errBuf.String(), tt.want) {
+				t.Errorf("expected %q in stderr, got: %s", tt.want, errBuf.String())
+			}
+		})
+	}
+}
+
+func TestBinary_Logout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "authentication-record.json")
+	if err := os.WriteFile(path, []byte(`{"username": "dev@example.com", "version": "1.0"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cached tokens of the signed-in account
+	msalCache := filepath.Join(dir, "cache", ".IdentityService", "docker-credential-acr")
+	tokenCache := filepath.Join(dir, "tokens", "entry.bin")
+	for _, file := range []string{msalCache, tokenCache} {
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("cached"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env := append(os.Environ(),
+		"DOCKER_CREDENTIAL_ACR_AUTH_RECORD="+path,
+		"XDG_CACHE_HOME="+filepath.Join(dir, "cache"),
+		"DOCKER_CREDENTIAL_ACR_CACHE=file",
+		"DOCKER_CREDENTIAL_ACR_CACHE_DIR="+filepath.Join(dir, "tokens"),
+		"DOCKER_CREDENTIAL_ACR_CACHE_KEY=passphrase",
+		"DOCKER_CREDENTIAL_ACR_CACHE_PASSPHRASE=test",
+	)
+	for _, want := range []string{"Signed out", "Not signed in"} {
+		cmd := exec.Command(binaryPath, "logout")
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		if err != nil || !strings.Contains(string(out), want) {
+			t.Fatalf("expected %q, got: %s (%v)", want, out, err)
+		}
+	}
+
+	removed := []string{path, tokenCache}
+	if runtime.GOOS == "linux" {
+		removed = append(removed, msalCache)
+	}
+	for _, file := range removed {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got: %v", file, err)
+		}
+	}
+}
+
+func TestBinary_CorruptLoginRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authentication-record.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := append(os.Environ(), "DOCKER_CREDENTIAL_ACR_AUTH_RECORD="+path)
+
+	// Commands that need no token keep working
+	for _, args := range [][]string{{"version"}, {"logout"}} {
+		cmd := exec.Command(binaryPath, args...)
+		cmd.Env = env
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s: expected success, got: %s (%v)", args[0], out, err)
+		}
+	}
+}
@@ -1,15 +1,71 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
 	"github.com/docker/docker-credential-helpers/credentials"
 	"github.com/mriedmann/acr-docker-credential-helper/acr"
 )
 
 func main() {
+	// The version needs neither configuration nor credentials
+	if len(os.Args) == 2 {
+		switch os.Args[1] {
+		case string(credentials.ActionVersion), "--version", "-v":
+			_ = credentials.PrintVersion(os.Stdout)
+			return
+		}
+	}
+
+	// Load optional settings from the config file and environment
+	cfg, err := acr.LoadConfig()
+	if err != nil {
+		fail(err)
+	}
+
+	// Commands managing the sign-in run without the helper, so a broken
+	// credential or cache setup can still be fixed with them
+	if len(os.Args) > 1 {
+		if run, ok := configCommands[os.Args[1]]; ok {
+			exitOnError(run(cfg, os.Args[2:]))
+			return
+		}
+	}
+
 	// Create ACR helper instance
-	helper := acr.NewACRHelper()
+	helper, err := acr.NewACRHelperWithConfig(cfg)
+	if err != nil {
+		fail(err)
+	}
+
+	// Run helper-specific commands (everything the credential helper
+	// protocol does not cover)
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			exitOnError(run(helper, os.Args[2:]))
+			return
+		}
+	}
 
 	// Serve the credential helper protocol
 	// This reads from stdin, routes to appropriate method, writes to stdout
 	credentials.Serve(helper)
 }
+
+// fail reports a startup error and exits.
+// The credential helper protocol expects errors on stdout.
+func fail(err error) {
+	fmt.Fprintln(os.Stdout, err)
+	os.Exit(1)
+}
+
+// exitOnError reports a subcommand error on stderr and exits
+func exitOnError(err error) {
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}